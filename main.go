@@ -72,19 +72,20 @@ func (a *Ayc) runBytecode(bytecodeFile string) {
 	if a.debug {
 		be.PrintBytecode()
 	}
-	vm := src.NewVM(be.Instructions)
-	vm.Exec()
+	a.exec(src.NewVM(be.Instructions))
 }
 
 func (a *Ayc) execInput(input *string) {
 	var lexer *src.Lexer
 	if input == nil {
-		lexer = src.NewLexer(*a.inputFile)
+		lexer = a.openInput()
 	} else {
 		lexer = src.NewInputLexer(*input)
 	}
-	parser := lexer.Tokenize()
-	ast := parser.Parse()
+	ast, ok := a.parse(lexer)
+	if !ok {
+		return
+	}
 	if a.optimize {
 		analyzer := src.NewAnalyzer(ast)
 		ast = analyzer.AnalyzeAndEval()
@@ -95,15 +96,15 @@ func (a *Ayc) execInput(input *string) {
 	be := src.NewBytecodeEmitter()
 	be.Walk(ast)
 	be.PrintBytecode()
-	vm := src.NewVM(be.Instructions)
-	vm.Exec()
+	a.exec(src.NewVM(be.Instructions))
 }
 
 func (a *Ayc) compileToFile() {
-	var lexer *src.Lexer
-	lexer = src.NewLexer(*a.inputFile)
-	parser := lexer.Tokenize()
-	ast := parser.Parse()
+	lexer := a.openInput()
+	ast, ok := a.parse(lexer)
+	if !ok {
+		return
+	}
 	if a.optimize {
 		analyzer := src.NewAnalyzer(ast)
 		ast = analyzer.AnalyzeAndEval()
@@ -122,6 +123,65 @@ func (a *Ayc) compileToFile() {
 	}
 }
 
+func (a *Ayc) openInput() *src.Lexer {
+	lexer, err := src.NewLexer(*a.inputFile)
+	if err != nil {
+		fmt.Println("Error reading file: ", err)
+		os.Exit(1)
+	}
+	return lexer
+}
+
+// parse loads the program and the modules it imports, then runs the resolver
+// and type checker, printing every error they find
+func (a *Ayc) parse(lexer *src.Lexer) (*src.AST, bool) {
+	loader := src.NewLoader()
+	ast, loadErrs := loader.Load(lexer)
+	if len(loadErrs) > 0 {
+		for _, err := range loadErrs {
+			fmt.Println(loader.Print(err))
+		}
+		a.fail()
+		return nil, false
+	}
+	resolver := src.NewResolver(ast)
+	ast, nameErrs := resolver.Resolve()
+	for _, w := range resolver.Warnings() {
+		fmt.Println(loader.Print(w))
+	}
+	if len(nameErrs) > 0 {
+		for _, err := range nameErrs {
+			fmt.Println(loader.Print(err))
+		}
+		a.fail()
+		return nil, false
+	}
+	ast, typeErrs := src.NewChecker(ast).Check()
+	if len(typeErrs) > 0 {
+		for _, err := range typeErrs {
+			fmt.Println(loader.Print(err))
+		}
+		a.fail()
+		return nil, false
+	}
+	return ast, true
+}
+
+// exec runs the program, reporting a runtime error like a compile error
+func (a *Ayc) exec(vm *src.GoVM) {
+	if err := vm.Exec(); err != nil {
+		fmt.Printf("%sruntime error:%s %v\n", src.Red, src.Reset, err)
+		a.fail()
+	}
+}
+
+// fail exits when compiling a file, the REPL keeps going
+func (a *Ayc) fail() {
+	if !a.repl {
+		os.Exit(1)
+	}
+}
+
 func (a *Ayc) execRepl() {
 	scanner := bufio.NewScanner(os.Stdin)
 	scanner.Split(bufio.ScanLines)
@@ -1,10 +1,14 @@
 package src
 
-import "fmt"
+import (
+	"math"
+	"strconv"
+	"strings"
+)
 
 type Analyzer struct {
 	prog      *AST
-	vars      map[string]Expr
+	vars      map[*Symbol]Expr
 	evaluated *Program
 }
 
@@ -13,7 +17,7 @@ type Analyzer struct {
 func NewAnalyzer(ast *AST) *Analyzer {
 	return &Analyzer{
 		prog:      ast,
-		vars:      make(map[string]Expr),
+		vars:      make(map[*Symbol]Expr),
 		evaluated: &Program{},
 	}
 }
@@ -23,7 +27,7 @@ func (an *Analyzer) AnalyzeAndEval() *AST {
 	for _, stmt := range stmts {
 		stmt.Accept(an)
 	}
-	return &AST{Root: an.evaluated}
+	return &AST{Root: an.evaluated, Spans: an.prog.Spans, Types: an.prog.Types, Symbols: an.prog.Symbols, Captures: an.prog.Captures, Modules: an.prog.Modules}
 }
 
 func (an *Analyzer) PrintOptimizedTree() {
@@ -36,94 +40,134 @@ func (an *Analyzer) pushNode(node Node) {
 	an.evaluated.Statements = append(an.evaluated.Statements, node)
 }
 
+// symbol is the variable node declares or refers to, as bound by the Resolver
+func (an *Analyzer) symbol(node Node) *Symbol {
+	return an.prog.Symbols[node]
+}
+
+// statements are rewritten in place rather than copied, the later passes find
+// their types and symbols by node
 func (an *Analyzer) Visit(node Node) {
 	switch n := node.(type) {
 	case *LetExpr:
 		if IsConstExpr(n.Value) {
-			cnst := an.attemptConstEval(n.Value)
-			an.vars[n.Variable.Name] = cnst
-			an.pushNode(&LetExpr{Variable: n.Variable, Value: cnst})
-		} else {
-			an.vars[n.Variable.Name] = n.Value
-			an.pushNode(n)
+			n.Value = an.attemptConstEval(n.Value)
 		}
+		an.vars[an.symbol(n)] = n.Value
+		an.pushNode(n)
 	case *CallExpr:
-		if _, ok := an.vars[n.Function.Name]; !ok {
-			panic(fmt.Sprintf("function %s used before declaration", n.Function.Name))
-		}
-		args := n.Args
-		tmp := []FuncArg{}
-		for _, arg := range args.Args {
+		for i, arg := range n.Args.Args {
 			if IsConstExpr(&arg) {
-				cnst := an.attemptConstEval(&arg)
-				tmp = append(tmp, FuncArg{Value: cnst})
-			} else {
-				tmp = append(tmp, arg)
+				n.Args.Args[i].Value = an.attemptConstEval(&arg)
 			}
 		}
-		an.pushNode(&CallExpr{Function: n.Function, Args: FuncArgs{Args: tmp}})
+		an.pushNode(n)
 	case *FuncDef:
-		params := n.Params
-		for _, param := range params {
-			an.vars[param.Name] = nil
-		}
-		for _, stmt := range n.Body.Statements {
-			an.Visit(stmt)
+		for i := range n.Params {
+			an.vars[an.symbol(&n.Params[i])] = nil
 		}
+		n.Body = an.analyzeBlock(n.Body)
+		an.pushNode(n)
 	case *Block:
 		for _, stmt := range n.Statements {
 			an.Visit(stmt)
 		}
+	case *WhileLoop:
+		cond := n.Condition
+		if IsConstExpr(cond) {
+			cond = an.attemptConstEval(cond)
+		}
+		if b, ok := cond.(*BoolLiteral); ok && !b.bool {
+			// the body can never run
+			return
+		}
+		n.Condition = cond
+		n.Body = an.analyzeBlock(n.Body)
+		an.pushNode(n)
+	case *ForLoop:
+		if IsConstExpr(n.Start) {
+			n.Start = an.attemptConstEval(n.Start)
+		}
+		// the loop variable changes every iteration, so it is never a constant
+		an.vars[an.symbol(n.Var)] = nil
+		if IsConstExpr(n.Condition) {
+			n.Condition = an.attemptConstEval(n.Condition)
+		}
+		n.Body = an.analyzeBlock(n.Body)
+		an.pushNode(n)
+	case *ForIn:
+		an.vars[an.symbol(n.Var)] = nil
+		n.Body = an.analyzeBlock(n.Body)
+		an.pushNode(n)
 	case *PrintCall:
 		if IsConstExpr(n.Value) {
 			n.Value = an.attemptConstEval(n.Value)
 		}
-		an.pushNode(&PrintCall{Value: n.Value})
+		an.pushNode(n)
 	case *ReAssignExpr:
-		if _, ok := an.vars[n.Variable.Name]; !ok {
-			panic(fmt.Sprintf("variable %s used before declaration", n.Variable))
-		}
 		if IsConstExpr(n.NewValue) {
 			n.NewValue = an.attemptConstEval(n.NewValue)
-			an.vars[n.Variable.Name] = n.NewValue
+			an.vars[an.symbol(n)] = n.NewValue
 		}
-		an.pushNode(&ReAssignExpr{Variable: n.Variable, NewValue: n.NewValue})
+		an.pushNode(n)
 	default:
 		an.pushNode(node)
 	}
 }
 
+// analyzeBlock evaluates the statements of a nested block into a new block,
+// instead of pushing them onto the top level program
+func (an *Analyzer) analyzeBlock(block Node) *Block {
+	outer := an.evaluated
+	an.evaluated = &Program{}
+	for _, stmt := range block.(*Block).Statements {
+		an.Visit(stmt)
+	}
+	evaluated := &Block{Statements: an.evaluated.Statements}
+	an.evaluated = outer
+	return evaluated
+}
+
 func (an *Analyzer) attemptConstEval(expr Expr) Expr {
 	switch e := expr.(type) {
 	case *BinaryExpr:
 		l := an.attemptConstEval(e.Left)
 		r := an.attemptConstEval(e.Right)
 		if l != nil && r != nil {
-			return an.evalBinaryExpr(e.Operator, l, r)
+			if folded := an.evalBinaryExpr(e.Operator, l, r); folded != nil {
+				return folded
+			}
 		}
 		return e
 	case *UnaryExpr:
-		operand := an.attemptConstEval(e.Operand)
-		if operand != nil {
-			return an.evalUnaryExpr(e.Operator, operand)
+		if folded := an.evalUnaryExpr(e.Operator, e.Operand); folded != nil {
+			return folded
 		}
 		return e
-	case *NumLiteral:
+	case *NumLiteral, *FloatLiteral, *StringLiteral, *BoolLiteral:
 		return e
-	case *Ident:
-		if v, ok := an.vars[e.Name]; ok {
-			switch ex := v.(type) {
-			case *NumLiteral:
-				return ex
-			case *BoolLiteral:
-				return ex
-			case *StringLiteral:
-				return ex
+	case *InterpString:
+		return an.evalInterpString(e)
+	case *IfExpr:
+		if cond, ok := an.attemptConstEval(e.Condition).(*BoolLiteral); ok {
+			if cond.bool {
+				return an.attemptConstEval(e.Then)
 			}
-			return e
-		} else {
-			panic(fmt.Sprintf("variable %s used before declaration", e.Name))
+			return an.attemptConstEval(e.Else)
+		}
+		return e
+	case *Ident:
+		switch ex := an.vars[an.symbol(e)].(type) {
+		case *NumLiteral:
+			return ex
+		case *FloatLiteral:
+			return ex
+		case *BoolLiteral:
+			return ex
+		case *StringLiteral:
+			return ex
 		}
+		return e
 	default:
 		return nil
 	}
@@ -137,9 +181,18 @@ func (an *Analyzer) evalBinaryExpr(op tokenKind, l, r Expr) Expr {
 	}
 	switch left := lhs.(type) {
 	case *NumLiteral:
-		rhs, ok := rhs.(*NumLiteral)
-		if ok {
+		switch rhs := rhs.(type) {
+		case *NumLiteral:
 			return handleConstMath(left, rhs, op)
+		case *FloatLiteral:
+			return handleConstFloatMath(float64(left.Value), rhs.Value, op)
+		}
+	case *FloatLiteral:
+		switch rhs := rhs.(type) {
+		case *NumLiteral:
+			return handleConstFloatMath(left.Value, float64(rhs.Value), op)
+		case *FloatLiteral:
+			return handleConstFloatMath(left.Value, rhs.Value, op)
 		}
 	case *BoolLiteral:
 		rhs, ok := rhs.(*BoolLiteral)
@@ -147,36 +200,60 @@ func (an *Analyzer) evalBinaryExpr(op tokenKind, l, r Expr) Expr {
 			return handleConstBoolLogic(left, rhs, op)
 		}
 	case *Ident:
-		if v, ok := an.vars[left.Name]; ok {
-			switch ex := v.(type) {
-			case *NumLiteral:
-				if rhs, ok := rhs.(*NumLiteral); ok {
-					return handleConstMath(ex, rhs, op)
-				}
-			case *BoolLiteral:
-				if rhs, ok := rhs.(*BoolLiteral); ok {
-					return handleConstBoolLogic(ex, rhs, op)
-				}
-			case *StringLiteral:
-				if rhs, ok := rhs.(*StringLiteral); ok {
-					if op == Plus {
-						return &StringLiteral{string: ex.string + rhs.string}
-					} else {
-						panic("Unknown operator for type string")
-					}
+		switch ex := an.vars[an.symbol(left)].(type) {
+		case *NumLiteral, *FloatLiteral:
+			return an.evalBinaryExpr(op, ex, rhs)
+		case *BoolLiteral:
+			if rhs, ok := rhs.(*BoolLiteral); ok {
+				return handleConstBoolLogic(ex, rhs, op)
+			}
+		case *StringLiteral:
+			if rhs, ok := rhs.(*StringLiteral); ok {
+				if op == Plus {
+					return &StringLiteral{string: ex.string + rhs.string}
+				} else {
+					panic("Unknown operator for type string")
 				}
-			case *Ident:
-				return an.evalBinaryExpr(op, ex, rhs)
-			default:
-				return nil
 			}
-		} else {
-			panic(fmt.Sprintf("variable %s used before declaration", left.Name))
+		case *Ident:
+			return an.evalBinaryExpr(op, ex, rhs)
+		default:
+			return nil
 		}
 	}
 	return nil
 }
 
+// evalInterpString folds every constant part, collapsing the whole string into
+// a StringLiteral when nothing is left to compute at runtime
+func (an *Analyzer) evalInterpString(s *InterpString) Expr {
+	parts := []Expr{}
+	var folded strings.Builder
+	isConst := true
+	for _, part := range s.Parts {
+		if IsConstExpr(part) {
+			part = an.attemptConstEval(part)
+		}
+		switch p := part.(type) {
+		case *StringLiteral:
+			folded.WriteString(p.string)
+		case *NumLiteral:
+			folded.WriteString(strconv.Itoa(p.Value))
+		case *FloatLiteral:
+			folded.WriteString(formatFloat(p.Value))
+		case *BoolLiteral:
+			folded.WriteString(strconv.FormatBool(p.bool))
+		default:
+			isConst = false
+		}
+		parts = append(parts, part)
+	}
+	if isConst {
+		return &StringLiteral{folded.String()}
+	}
+	return &InterpString{Parts: parts}
+}
+
 func handleConstBoolLogic(lhs, rhs *BoolLiteral, op tokenKind) Expr {
 	switch op {
 	case EqEq:
@@ -185,7 +262,7 @@ func handleConstBoolLogic(lhs, rhs *BoolLiteral, op tokenKind) Expr {
 		return &BoolLiteral{lhs.bool != rhs.bool}
 	case And:
 		return &BoolLiteral{lhs.bool && rhs.bool}
-	case Not:
+	case Or:
 		return &BoolLiteral{lhs.bool || rhs.bool}
 	}
 	return nil
@@ -200,7 +277,16 @@ func handleConstMath(lhs, rhs *NumLiteral, op tokenKind) Expr {
 	case Mul:
 		return &NumLiteral{Value: lhs.Value * rhs.Value}
 	case Div:
+		if rhs.Value == 0 {
+			// leave it for the VM to fail on
+			return nil
+		}
 		return &NumLiteral{Value: lhs.Value / rhs.Value}
+	case Mod:
+		if rhs.Value == 0 {
+			return nil
+		}
+		return &NumLiteral{Value: lhs.Value % rhs.Value}
 	case EqEq:
 		return &BoolLiteral{lhs.Value == rhs.Value}
 	case Neq:
@@ -214,8 +300,14 @@ func handleConstMath(lhs, rhs *NumLiteral, op tokenKind) Expr {
 	case Lte:
 		return &BoolLiteral{lhs.Value <= rhs.Value}
 	case LShift:
+		if rhs.Value < 0 {
+			return nil
+		}
 		return &NumLiteral{Value: lhs.Value << rhs.Value}
 	case RShift:
+		if rhs.Value < 0 {
+			return nil
+		}
 		return &NumLiteral{Value: lhs.Value >> rhs.Value}
 	case BitAnd:
 		return &NumLiteral{Value: lhs.Value & rhs.Value}
@@ -228,51 +320,54 @@ func handleConstMath(lhs, rhs *NumLiteral, op tokenKind) Expr {
 	}
 }
 
+func handleConstFloatMath(lhs, rhs float64, op tokenKind) Expr {
+	switch op {
+	case Plus:
+		return &FloatLiteral{Value: lhs + rhs}
+	case Minus:
+		return &FloatLiteral{Value: lhs - rhs}
+	case Mul:
+		return &FloatLiteral{Value: lhs * rhs}
+	case Div:
+		return &FloatLiteral{Value: lhs / rhs}
+	case Mod:
+		return &FloatLiteral{Value: math.Mod(lhs, rhs)}
+	case EqEq:
+		return &BoolLiteral{lhs == rhs}
+	case Neq:
+		return &BoolLiteral{lhs != rhs}
+	case Gt:
+		return &BoolLiteral{lhs > rhs}
+	case Gte:
+		return &BoolLiteral{lhs >= rhs}
+	case Lt:
+		return &BoolLiteral{lhs < rhs}
+	case Lte:
+		return &BoolLiteral{lhs <= rhs}
+	default:
+		panic("Unknown operator for type float")
+	}
+}
+
+// evalUnaryExpr folds a prefix operator applied to a constant operand,
+// returning nil when the operand or the operator can't be evaluated
 func (an *Analyzer) evalUnaryExpr(op tokenKind, operand Expr) Expr {
-	operand = an.attemptConstEval(operand)
-	switch operand.(type) {
+	switch o := an.attemptConstEval(operand).(type) {
+	case *BoolLiteral:
+		if op == Not || op == Bang {
+			return &BoolLiteral{!o.bool}
+		}
+	case *FloatLiteral:
+		if op == Minus {
+			return &FloatLiteral{Value: -o.Value}
+		}
 	case *NumLiteral:
-		val := operand.(*NumLiteral).Value
 		switch op {
 		case Minus:
-			return &NumLiteral{Value: -val}
+			return &NumLiteral{Value: -o.Value}
 		case BitNot:
-			return &NumLiteral{Value: ^val}
-		default:
-			panic("Unknown operator")
-		}
-	case *Ident:
-		val := operand.(*Ident)
-		if v, ok := an.vars[val.Name]; ok {
-			switch ex := v.(type) {
-			case *NumLiteral:
-				switch op {
-				case BitNot:
-					return &NumLiteral{Value: ^ex.Value}
-				case Minus:
-					return &NumLiteral{Value: -ex.Value}
-				default:
-					// not a constexpr
-					return operand
-				}
-			case *BoolLiteral:
-				switch op {
-				case Not:
-					return &BoolLiteral{!ex.bool}
-				default:
-					// not a constexpr
-					return operand
-				}
-			case *Ident:
-				// try to resolve the identifier
-				return an.evalUnaryExpr(op, ex)
-			default:
-				// not a constexpr
-				return operand
-			}
-		} else {
-			panic(fmt.Sprintf("variable %s used before declaration", val.Name))
+			return &NumLiteral{Value: ^o.Value}
 		}
 	}
-	return operand
+	return nil
 }
@@ -0,0 +1,62 @@
+package src
+
+import (
+	"math"
+	"testing"
+)
+
+// optimize checks src and runs the Analyzer over it
+func optimize(t *testing.T, src string) []Node {
+	t.Helper()
+	return NewAnalyzer(check(t, NewInputLexer(src))).AnalyzeAndEval().Root.(*Program).Statements
+}
+
+func TestFoldInterpolation(t *testing.T) {
+	stmts := optimize(t, `
+print("n is {1 + 2}, {1.5} {true}")
+let s = input_str("name")
+print("hi {s}, {2 * 2}")
+`)
+	folded, ok := stmts[0].(*PrintCall).Value.(*StringLiteral)
+	if !ok || folded.string != "n is 3, 1.5 true" {
+		t.Errorf("expected a constant string, got %#v", stmts[0].(*PrintCall).Value)
+	}
+	// a part only known at runtime keeps the whole string interpolated
+	if interp, ok := stmts[2].(*PrintCall).Value.(*InterpString); !ok || len(interp.Parts) != 4 {
+		t.Errorf("expected an interpolated string, got %#v", stmts[2].(*PrintCall).Value)
+	}
+}
+
+func TestFoldArithmetic(t *testing.T) {
+	stmts := optimize(t, `
+let a = 0x10 + 0b11 * 2
+let b = 7 / 2
+let c = 1.5 * 2
+let d = 10 / 0
+let e = 10 % 0
+let f = 1 / 0.0
+`)
+	want := []Expr{&NumLiteral{22}, &NumLiteral{3}, &FloatLiteral{3}}
+	for i, w := range want {
+		got := stmts[i].(*LetExpr).Value
+		switch w := w.(type) {
+		case *NumLiteral:
+			if num, ok := got.(*NumLiteral); !ok || num.Value != w.Value {
+				t.Errorf("statement %d: got %#v, want %d", i, got, w.Value)
+			}
+		case *FloatLiteral:
+			if num, ok := got.(*FloatLiteral); !ok || num.Value != w.Value {
+				t.Errorf("statement %d: got %#v, want %v", i, got, w.Value)
+			}
+		}
+	}
+	// integer division by zero is left for the VM to report
+	for _, i := range []int{3, 4} {
+		if _, ok := stmts[i].(*LetExpr).Value.(*BinaryExpr); !ok {
+			t.Errorf("statement %d: expected the division to be left alone, got %#v", i, stmts[i].(*LetExpr).Value)
+		}
+	}
+	if f, ok := stmts[5].(*LetExpr).Value.(*FloatLiteral); !ok || !math.IsInf(f.Value, 1) {
+		t.Errorf("expected a float division by zero to fold to +Inf, got %#v", stmts[5].(*LetExpr).Value)
+	}
+}
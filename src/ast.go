@@ -7,7 +7,27 @@ type Visitor interface {
 }
 
 type AST struct {
-	Root Node
+	Root  Node
+	Spans map[Node]Span // where each statement and expression came from
+	Types map[Expr]Type // filled in by the Checker
+	// Symbols binds each Ident, assignment and call to the declaration it
+	// refers to, and each declaration to its own Symbol. Filled in by the Resolver
+	Symbols map[Node]*Symbol
+	// Captures lists the variables of enclosing functions each Lambda uses,
+	// in the order it first uses them. Filled in by the Resolver
+	Captures map[*Lambda][]*Symbol
+	// Modules are the files of the program, each after the modules it
+	// imports. Root holds their statements in the same order. Nil when the
+	// program wasn't read by a Loader, it is then a single module
+	Modules []*Module
+}
+
+// modules is every module of the program, in the order they run
+func (ast *AST) modules() []*Module {
+	if ast.Modules != nil {
+		return ast.Modules
+	}
+	return []*Module{{Statements: ast.Root.(*Program).Statements}}
 }
 
 type Program struct {
@@ -80,10 +100,36 @@ func (s *StringLiteral) Accept(visitor Visitor) {
 	visitor.Visit(s)
 }
 
+// InterpString is a string literal with embedded expressions, e.g. "hi {name}".
+// Parts are StringLiterals and the expressions between them, in order.
+type InterpString struct {
+	Parts []Expr
+}
+
+func (s *InterpString) Print() {
+	fmt.Printf("InterpString: %v\n", s.Parts)
+}
+
+func (s *InterpString) Accept(visitor Visitor) {
+	visitor.Visit(s)
+}
+
 type NumLiteral struct {
 	Value int
 }
 
+type FloatLiteral struct {
+	Value float64
+}
+
+func (f *FloatLiteral) Accept(visitor Visitor) {
+	visitor.Visit(f)
+}
+
+func (f *FloatLiteral) Print() {
+	fmt.Printf("FloatLiteral: %v\n", f.Value)
+}
+
 type BoolLiteral struct {
 	bool
 }
@@ -98,7 +144,14 @@ func (b *BoolLiteral) Print() {
 
 func IsConstExpr(expr Expr) bool {
 	switch e := expr.(type) {
-	case *NumLiteral:
+	case *NumLiteral, *FloatLiteral, *StringLiteral, *BoolLiteral:
+		return true
+	case *InterpString:
+		for _, part := range e.Parts {
+			if !IsConstExpr(part) {
+				return false
+			}
+		}
 		return true
 	case *Ident:
 		return false
@@ -106,6 +159,8 @@ func IsConstExpr(expr Expr) bool {
 		return IsConstExpr(e.Left) && IsConstExpr(e.Right)
 	case *UnaryExpr:
 		return IsConstExpr(e.Operand)
+	case *IfExpr:
+		return IsConstExpr(e.Condition) && IsConstExpr(e.Then) && IsConstExpr(e.Else)
 	default:
 		return false
 	}
@@ -134,9 +189,184 @@ func (a *Array) Print() {
 	fmt.Printf("Array: %v\n", a.Items)
 }
 
+// IndexExpr reads an element of an array, e.g. `a[i]`
+type IndexExpr struct {
+	Target Expr
+	Index  Expr
+}
+
+func (i *IndexExpr) Accept(visitor Visitor) {
+	visitor.Visit(i)
+}
+
+func (i *IndexExpr) Print() {
+	fmt.Printf("IndexExpr: %v[%v]\n", i.Target, i.Index)
+}
+
+// IndexAssign writes an element of an array, e.g. `a[i] = v`. Compound
+// assignments are desugared like they are for variables, the value reads the
+// element through the same Target and Index nodes
+type IndexAssign struct {
+	Target Expr
+	Index  Expr
+	Value  Expr
+}
+
+func (i *IndexAssign) Accept(visitor Visitor) {
+	visitor.Visit(i)
+}
+
+func (i *IndexAssign) Print() {
+	fmt.Printf("IndexAssign: %v[%v] = %v\n", i.Target, i.Index, i.Value)
+}
+
+// StructDef declares a struct, e.g. `struct Point { x: int, y: int }`
+type StructDef struct {
+	Name   Ident
+	Fields []StructField
+	Doc    string // from the `///` comments above the declaration
+	Pub    bool
+}
+
+type StructField struct {
+	Name string
+	Type Type
+}
+
+func (s *StructDef) Accept(visitor Visitor) {
+	visitor.Visit(s)
+}
+
+func (s *StructDef) Print() {
+	fmt.Printf("StructDef: %s %v\n", s.Name.Name, s.Fields)
+}
+
+// StructLit constructs a struct, e.g. `Point { x: 1, y: 2 }`. Fields are
+// in the order they were written, which needn't match the declaration
+type StructLit struct {
+	Name   Ident
+	Fields []FieldInit
+}
+
+type FieldInit struct {
+	Name  string
+	Value Expr
+}
+
+func (s *StructLit) Accept(visitor Visitor) {
+	visitor.Visit(s)
+}
+
+func (s *StructLit) Print() {
+	fmt.Printf("StructLit: %s %v\n", s.Name.Name, s.Fields)
+}
+
+// FieldExpr reads a field of a struct, e.g. `p.x`
+type FieldExpr struct {
+	Target Expr
+	Field  string
+}
+
+func (f *FieldExpr) Accept(visitor Visitor) {
+	visitor.Visit(f)
+}
+
+func (f *FieldExpr) Print() {
+	fmt.Printf("FieldExpr: %v.%s\n", f.Target, f.Field)
+}
+
+// FieldAssign writes a field of a struct, e.g. `p.x = 1`
+type FieldAssign struct {
+	Target Expr
+	Field  string
+	Value  Expr
+}
+
+func (f *FieldAssign) Accept(visitor Visitor) {
+	visitor.Visit(f)
+}
+
+func (f *FieldAssign) Print() {
+	fmt.Printf("FieldAssign: %v.%s = %v\n", f.Target, f.Field, f.Value)
+}
+
+// LenCall is the builtin `len(a)`, for arrays, maps and strings
+type LenCall struct {
+	Value Expr
+}
+
+func (l *LenCall) Accept(visitor Visitor) {
+	visitor.Visit(l)
+}
+
+func (l *LenCall) Print() {
+	fmt.Printf("LenCall: %v\n", l.Value)
+}
+
+// MapLit is a map literal, e.g. `{"a": 1, "b": 2}`. Keys[i] maps to Values[i]
+type MapLit struct {
+	Keys   []Expr
+	Values []Expr
+}
+
+func (m *MapLit) Accept(visitor Visitor) {
+	visitor.Visit(m)
+}
+
+func (m *MapLit) Print() {
+	fmt.Printf("MapLit: %v %v\n", m.Keys, m.Values)
+}
+
+// HasCall is the builtin `has(m, k)`, whether a map holds a key
+type HasCall struct {
+	Map Expr
+	Key Expr
+}
+
+func (h *HasCall) Accept(visitor Visitor) {
+	visitor.Visit(h)
+}
+
+func (h *HasCall) Print() {
+	fmt.Printf("HasCall: %v %v\n", h.Map, h.Key)
+}
+
+// KeysCall is the builtin `keys(m)`, an array of the keys of a map in the
+// order they were inserted
+type KeysCall struct {
+	Map Expr
+}
+
+func (k *KeysCall) Accept(visitor Visitor) {
+	visitor.Visit(k)
+}
+
+func (k *KeysCall) Print() {
+	fmt.Printf("KeysCall: %v\n", k.Map)
+}
+
+// DeleteCall is the builtin `delete(m, k)`, deleting a missing key does nothing
+type DeleteCall struct {
+	Map Expr
+	Key Expr
+}
+
+func (d *DeleteCall) Accept(visitor Visitor) {
+	visitor.Visit(d)
+}
+
+func (d *DeleteCall) Print() {
+	fmt.Printf("DeleteCall: %v %v\n", d.Map, d.Key)
+}
+
+// ForLoop is a C-style `for (let i = 0; i < n; i = i + 1) { }` loop. Var is the
+// loop variable and Start its initial value, when IsDecl is set the variable
+// was declared with `let` and only lives for the duration of the loop.
 type ForLoop struct {
 	Var       Expr
+	Type      Type // from `let i: type = ...`, nil when the type is inferred
 	Start     Expr
+	IsDecl    bool
 	Condition Expr
 	Step      Expr
 	Body      Node
@@ -150,6 +380,35 @@ func (f *ForLoop) Print() {
 	fmt.Printf("ForLoop: %v %v %v %v\n", f.Var, f.Start, f.Condition, f.Step)
 }
 
+// ForIn loops over the items of an array or the keys of a map, e.g.
+// `for name in names { }`. Var only lives for the duration of the loop
+type ForIn struct {
+	Var      *Ident
+	Iterable Expr
+	Body     Node
+}
+
+func (f *ForIn) Accept(visitor Visitor) {
+	visitor.Visit(f)
+}
+
+func (f *ForIn) Print() {
+	fmt.Printf("ForIn: %v in %v\n", f.Var, f.Iterable)
+}
+
+type WhileLoop struct {
+	Condition Expr
+	Body      Node
+}
+
+func (w *WhileLoop) Accept(visitor Visitor) {
+	visitor.Visit(w)
+}
+
+func (w *WhileLoop) Print() {
+	fmt.Printf("WhileLoop: %v %v\n", w.Condition, w.Body)
+}
+
 func (i *Ident) Accept(visitor Visitor) {
 	visitor.Visit(i)
 }
@@ -164,7 +423,23 @@ type Stmt interface {
 
 type LetExpr struct {
 	Variable Ident
+	Type     Type // from `let x: type = ...`, nil when the type is inferred
 	Value    Expr
+	Pub      bool // visible to the modules that import this one
+}
+
+// ImportDecl makes the names another module exports visible, e.g. `import "lib/util"`.
+// The path is relative to the importing file and may leave out `.ayc`
+type ImportDecl struct {
+	Path string
+}
+
+func (i *ImportDecl) Accept(visitor Visitor) {
+	visitor.Visit(i)
+}
+
+func (i *ImportDecl) Print() {
+	fmt.Printf("ImportDecl: %q\n", i.Path)
 }
 
 type ReAssignExpr struct {
@@ -185,7 +460,7 @@ func (a *LetExpr) Accept(visitor Visitor) {
 }
 
 func (a *LetExpr) Print() {
-	fmt.Printf("LetExpr: %v = %s\n", a.Variable, a.Value)
+	fmt.Printf("LetExpr: %v: %v = %s\n", a.Variable, a.Type, a.Value)
 }
 
 type PrintCall struct {
@@ -216,10 +491,14 @@ func (i *InputStrCall) Print() {
 }
 
 type CallExpr struct {
-	Function    Ident
-	Args        FuncArgs
-	IsRecursive bool
-	IsTail      bool
+	Function Ident
+	// Callee computes the function value to call when it isn't named, e.g.
+	// `mk()` in `mk()(2)`, nil for a call by name
+	Callee Expr
+	Args   FuncArgs
+	// Builtin is what the Resolver lowered a call of a builtin to, e.g. a
+	// LenCall, nil when a declared function or variable is called
+	Builtin Expr
 }
 
 type FuncArg struct {
@@ -242,7 +521,7 @@ func (c *CallExpr) Print() {
 }
 
 type ReturnExpr struct {
-	Value Expr
+	Value Expr // nil for a bare `return`
 }
 
 func (r *ReturnExpr) Accept(visitor Visitor) {
@@ -257,12 +536,38 @@ type FuncDef struct {
 	Name    Ident
 	Params  []FnParam
 	Body    *Block
-	RetType tokenKind
+	RetType Type
+	Doc     string // from the `///` comments above the definition
+	Pub     bool
+}
+
+// Lambda is an anonymous function, e.g. `fn(x: int) -> int { return x * 2 }`.
+// It closes over the variables of the functions around it
+type Lambda struct {
+	Params  []FnParam
+	Body    *Block
+	RetType Type
+}
+
+func (l *Lambda) Accept(visitor Visitor) {
+	visitor.Visit(l)
+}
+
+func (l *Lambda) Print() {
+	fmt.Printf("Lambda: %v -> %v\n", l.Params, l.RetType)
 }
 
 type FnParam struct {
 	Name string
-	Type tokenKind
+	Type Type // nil when the parameter wasn't annotated
+}
+
+func (f *FnParam) Accept(visitor Visitor) {
+	visitor.Visit(f)
+}
+
+func (f *FnParam) Print() {
+	fmt.Printf("FnParam: %s %v\n", f.Name, f.Type)
 }
 
 type FuncArgs struct {
@@ -295,7 +600,7 @@ func (f *FuncDef) Accept(visitor Visitor) {
 }
 
 func (f *FuncDef) Print() {
-	fmt.Printf("Func: body: %v, params: %v, retType: %s", f.Body, f.Params, f.RetType.ToString())
+	fmt.Printf("Func: body: %v, params: %v, retType: %v", f.Body, f.Params, f.RetType)
 }
 
 func (i *InputIntCall) Accept(visitor Visitor) {
@@ -306,7 +611,8 @@ func (i *InputIntCall) Print() {
 	fmt.Printf("InputCall %s", i.Input)
 }
 
-// IfStmt represents a conditional expression (e.g., `if ... then ... else ...`)
+// IfStmt represents a conditional statement (e.g., `if ... { } else if ... { } else { }`),
+// an `else if` is an ElseBlock holding just the nested IfStmt
 type IfStmt struct {
 	Condition Expr
 	IfBlock   Node
@@ -319,3 +625,18 @@ func (i *IfStmt) Accept(visitor Visitor) {
 func (i *IfStmt) Print() {
 	fmt.Printf("IfExpr: if %v then %v\n else %v\n", i.Condition, i.IfBlock, i.ElseBlock)
 }
+
+// IfExpr is the expression form of a conditional, e.g. `if c then a else b`
+type IfExpr struct {
+	Condition Expr
+	Then      Expr
+	Else      Expr
+}
+
+func (i *IfExpr) Accept(visitor Visitor) {
+	visitor.Visit(i)
+}
+
+func (i *IfExpr) Print() {
+	fmt.Printf("IfExpr: if %v then %v else %v\n", i.Condition, i.Then, i.Else)
+}
@@ -0,0 +1,877 @@
+package src
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Checker is the type checking pass between the resolver and codegen. It infers
+// the type of every expression into AST.Types, and records a TypeError for each
+// mismatch instead of stopping at the first one.
+type Checker struct {
+	ast        *AST
+	types      map[Expr]Type
+	symTypes   map[*Symbol]Type // the type of each variable and parameter
+	sigs       map[*Symbol]*FuncType
+	structs    map[*Module]map[string]*StructType // declared by each module
+	structDefs map[*StructDef]*StructType         // the top level declarations, nil for duplicates
+	pubStructs map[*StructType]bool               // visible to the modules that import theirs
+	module     *Module                            // being checked
+	ret        Type                               // of the function or lambda being checked, nil at the top level
+	stmt       Node                               // the statement being checked, for nodes without a span of their own
+	errors     []TypeError
+}
+
+// NewChecker takes an AST the Resolver has already bound names in
+func NewChecker(ast *AST) *Checker {
+	return &Checker{
+		ast:        ast,
+		types:      make(map[Expr]Type),
+		symTypes:   make(map[*Symbol]Type),
+		sigs:       make(map[*Symbol]*FuncType),
+		structs:    make(map[*Module]map[string]*StructType),
+		structDefs: make(map[*StructDef]*StructType),
+		pubStructs: make(map[*StructType]bool),
+	}
+}
+
+// Check returns the typed tree, the same AST with Types filled in
+func (c *Checker) Check() (*AST, []TypeError) {
+	modules := c.ast.modules()
+	// eachStmt visits the top level statements of every module in order
+	eachStmt := func(visit func(stmt Node)) {
+		for _, mod := range modules {
+			c.module = mod
+			for _, stmt := range mod.Statements {
+				visit(stmt)
+			}
+		}
+	}
+	// structs are declared before their fields are looked at, so a field
+	// can name any struct, including the one it belongs to
+	eachStmt(func(stmt Node) {
+		if def, ok := stmt.(*StructDef); ok {
+			c.declareStruct(def)
+		}
+	})
+	eachStmt(func(stmt Node) {
+		if def, ok := stmt.(*StructDef); ok {
+			c.defineFields(def)
+		}
+	})
+	// every top level signature is known up front, so functions can call
+	// each other regardless of which is defined first
+	eachStmt(func(stmt Node) {
+		if fn, ok := stmt.(*FuncDef); ok {
+			c.declareFunc(fn)
+		}
+	})
+	eachStmt(c.Visit)
+	c.ast.Types = c.types
+	return c.ast, c.errors
+}
+
+func (c *Checker) errorAt(node Node, msg string) {
+	span, ok := c.ast.Spans[node]
+	if !ok {
+		span = c.ast.Spans[c.stmt]
+	}
+	c.errors = append(c.errors, TypeError{Span: span, Msg: msg})
+}
+
+// define records the type of the variable node declares
+func (c *Checker) define(node Node, typ Type) {
+	if sym, ok := c.ast.Symbols[node]; ok {
+		c.symTypes[sym] = typ
+	}
+}
+
+func (c *Checker) declareStruct(def *StructDef) {
+	if _, exists := c.structs[c.module][def.Name.Name]; exists {
+		c.errorAt(def, fmt.Sprintf("Struct %s is already declared", def.Name.Name))
+		c.structDefs[def] = nil
+		return
+	}
+	if c.structs[c.module] == nil {
+		c.structs[c.module] = make(map[string]*StructType)
+	}
+	st := &StructType{Name: def.Name.Name, Module: c.module.Name}
+	c.structs[c.module][st.Name] = st
+	c.structDefs[def] = st
+	c.pubStructs[st] = def.Pub
+}
+
+// structNamed finds a struct declared by the module being checked, or
+// exported by one it imports
+func (c *Checker) structNamed(name string) (*StructType, bool) {
+	if st, ok := c.structs[c.module][name]; ok {
+		return st, true
+	}
+	for _, dep := range c.module.Deps {
+		if st, ok := c.structs[dep][name]; ok && c.pubStructs[st] {
+			return st, true
+		}
+	}
+	return nil, false
+}
+
+func (c *Checker) defineFields(def *StructDef) {
+	st := c.structDefs[def]
+	if st == nil {
+		return
+	}
+	for _, field := range def.Fields {
+		typ := c.resolveType(field.Type, def)
+		if typ == VoidType {
+			c.errorAt(def, fmt.Sprintf("Field %s of %s can't be void", field.Name, st.Name))
+			typ = invalidType
+		}
+		st.Fields = append(st.Fields, StructField{Name: field.Name, Type: typ})
+	}
+}
+
+// resolveType swaps the struct names in an annotation for the declared
+// structs, reporting names that aren't declared at node
+func (c *Checker) resolveType(t Type, node Node) Type {
+	switch t := t.(type) {
+	case *StructType:
+		if st, ok := c.structNamed(t.Name); ok {
+			return st
+		}
+		c.errorAt(node, fmt.Sprintf("Unknown type %s", t.Name))
+		return invalidType
+	case *ArrayType:
+		elem := c.resolveType(t.Elem, node)
+		if elem == invalidType {
+			return invalidType
+		}
+		return &ArrayType{Elem: elem}
+	case *MapType:
+		key, value := c.resolveType(t.Key, node), c.resolveType(t.Value, node)
+		if key == invalidType || value == invalidType {
+			return invalidType
+		}
+		if !isHashable(key) {
+			c.errorAt(node, fmt.Sprintf("Map keys must be int, str or bool, got %v", key))
+			return invalidType
+		}
+		return &MapType{Key: key, Value: value}
+	case *FuncType:
+		sig := &FuncType{Params: make([]Type, len(t.Params)), Ret: c.resolveType(t.Ret, node)}
+		for i, param := range t.Params {
+			sig.Params[i] = c.resolveType(param, node)
+			if sig.Params[i] == invalidType {
+				return invalidType
+			}
+		}
+		if sig.Ret == invalidType {
+			return invalidType
+		}
+		return sig
+	}
+	return t
+}
+
+// declareFunc records the signature of fn, a parameter without a type is
+// reported here once rather than at every call
+func (c *Checker) declareFunc(fn *FuncDef) *FuncType {
+	sym := c.ast.Symbols[fn]
+	if sig, ok := c.sigs[sym]; ok {
+		return sig
+	}
+	sig := &FuncType{Params: make([]Type, len(fn.Params)), Ret: c.resolveType(fn.RetType, fn)}
+	for i, param := range fn.Params {
+		if param.Type == nil || param.Type == VoidType {
+			c.errorAt(&fn.Params[i], fmt.Sprintf("Parameter %s of %s needs a type", param.Name, fn.Name.Name))
+			sig.Params[i] = invalidType
+			continue
+		}
+		sig.Params[i] = c.resolveType(param.Type, &fn.Params[i])
+	}
+	c.sigs[sym] = sig
+	return sig
+}
+
+// lookup is the type of the variable or function node refers to, names the
+// Resolver couldn't bind were already reported
+func (c *Checker) lookup(node Node) Type {
+	sym := c.ast.Symbols[node]
+	if sym != nil && sym.Kind == FuncSymbol {
+		return c.declareFunc(sym.Decl.(*FuncDef))
+	}
+	if typ, ok := c.symTypes[sym]; ok {
+		return typ
+	}
+	return invalidType
+}
+
+func (c *Checker) Visit(node Node) {
+	if _, ok := c.ast.Spans[node]; ok {
+		outer := c.stmt
+		c.stmt = node
+		defer func() { c.stmt = outer }()
+	}
+	switch n := node.(type) {
+	case nil:
+		return
+	case *FuncDef:
+		c.checkBody(n, n.Params, n.Body, c.declareFunc(n))
+	case *Block:
+		c.visitBlock(n)
+	case *ImportDecl:
+		// the Loader and Resolver already followed it
+		return
+	case *StructDef:
+		if _, ok := c.structDefs[n]; !ok {
+			c.errorAt(n, "Structs can only be declared at the top level")
+		}
+	case *LetExpr:
+		declared := c.resolveType(n.Type, n)
+		typ := c.checkExprAs(n.Value, declared)
+		if declared != nil {
+			if !assignable(declared, typ) && typ != invalidType && declared != invalidType {
+				c.errorAt(n.Value, fmt.Sprintf("Cannot assign %v to %s, which is declared as %v", typ, n.Variable.Name, declared))
+			}
+			typ = declared
+		} else if typ == VoidType {
+			c.errorAt(n.Value, fmt.Sprintf("Cannot assign a void value to %s", n.Variable.Name))
+			typ = invalidType
+		}
+		c.define(n, typ)
+	case *FieldAssign:
+		field := c.checkField(n, n.Target, n.Field)
+		typ := c.checkExprAs(n.Value, field)
+		c.expectAssignable(n.Value, field, typ, fmt.Sprintf("Cannot assign %v to %s, which has type %v", typ, n.Field, field))
+	case *IndexAssign:
+		elem := c.checkIndex(n.Target, n.Index)
+		typ := c.checkExprAs(n.Value, elem)
+		c.expectAssignable(n.Value, elem, typ, fmt.Sprintf("Cannot assign %v to an element of %v", typ, c.types[n.Target]))
+	case *ReAssignExpr:
+		varType := c.lookup(n)
+		typ := c.checkExprAs(n.NewValue, varType)
+		c.expectAssignable(n.NewValue, varType, typ, fmt.Sprintf("Cannot assign %v to %s, which has type %v", typ, n.Variable.Name, varType))
+	case *PrintCall:
+		if c.checkExpr(n.Value) == VoidType {
+			c.errorAt(n.Value, "Cannot print a void value")
+		}
+	case *ReturnExpr:
+		if c.ret == nil {
+			c.checkExpr(n.Value)
+			c.errorAt(n, "return outside of a function")
+			return
+		}
+		retType := c.ret
+		if n.Value == nil {
+			if retType != VoidType && retType != invalidType {
+				c.errorAt(n, fmt.Sprintf("Expected a return value of type %v", retType))
+			}
+			return
+		}
+		typ := c.checkExprAs(n.Value, retType)
+		c.expectAssignable(n.Value, retType, typ, fmt.Sprintf("Expected return type %v, got %v", retType, typ))
+	case *IfStmt:
+		c.expectCondition(n.Condition)
+		c.visitBlock(n.IfBlock)
+		c.visitBlock(n.ElseBlock)
+	case *WhileLoop:
+		c.expectCondition(n.Condition)
+		c.visitBlock(n.Body)
+	case *ForLoop:
+		ident := n.Var.(*Ident)
+		declared := c.resolveType(n.Type, n)
+		typ := c.checkExprAs(n.Start, declared)
+		if n.IsDecl {
+			if declared != nil {
+				if !assignable(declared, typ) && typ != invalidType && declared != invalidType {
+					c.errorAt(n.Start, fmt.Sprintf("Cannot assign %v to %s, which is declared as %v", typ, ident.Name, declared))
+				}
+				typ = declared
+			}
+			c.define(n, typ)
+		} else {
+			varType := c.lookup(ident)
+			c.expectAssignable(n.Start, varType, typ, fmt.Sprintf("Cannot assign %v to %s, which has type %v", typ, ident.Name, varType))
+		}
+		c.types[ident] = c.lookup(ident)
+		c.expectCondition(n.Condition)
+		c.visitBlock(n.Body)
+		c.Visit(n.Step)
+	case *ForIn:
+		typ := c.checkExpr(n.Iterable)
+		switch t := typ.(type) {
+		case *ArrayType:
+			typ = t.Elem
+		case *MapType:
+			typ = t.Key
+		default:
+			if typ != invalidType {
+				c.errorAt(n.Iterable, fmt.Sprintf("Cannot iterate over %v", typ))
+			}
+			typ = invalidType
+		}
+		c.define(n, typ)
+		c.types[n.Var] = typ
+		c.visitBlock(n.Body)
+	default:
+		// expression statements, e.g. a call
+		c.checkExpr(node)
+	}
+}
+
+// checkBody checks the statements of the function or lambda fn with signature
+// sig, which must return a value on every path unless it returns void
+func (c *Checker) checkBody(fn Node, params []FnParam, body *Block, sig *FuncType) {
+	outer := c.ret
+	c.ret = sig.Ret
+	for i := range params {
+		c.define(&params[i], sig.Params[i])
+	}
+	for _, stmt := range body.Statements {
+		c.Visit(stmt)
+	}
+	c.ret = outer
+	if sig.Ret != VoidType && sig.Ret != invalidType && !alwaysReturns(body) {
+		c.errorAt(fn, fmt.Sprintf("Missing return, the body can end without returning %v", sig.Ret))
+	}
+}
+
+// alwaysReturns reports whether a statement can't complete without returning,
+// either by returning on every path or by looping forever
+func alwaysReturns(stmt Node) bool {
+	switch s := stmt.(type) {
+	case *ReturnExpr:
+		return true
+	case *Block:
+		for _, stmt := range s.Statements {
+			if alwaysReturns(stmt) {
+				return true
+			}
+		}
+	case *IfStmt:
+		return s.ElseBlock != nil && alwaysReturns(s.IfBlock) && alwaysReturns(s.ElseBlock)
+	case *WhileLoop:
+		// there is no break, so only a return leaves `while true`
+		cond, ok := s.Condition.(*BoolLiteral)
+		return ok && cond.bool
+	}
+	return false
+}
+
+func (c *Checker) visitBlock(node Node) {
+	block, ok := node.(*Block)
+	if !ok {
+		return
+	}
+	for _, stmt := range block.Statements {
+		c.Visit(stmt)
+	}
+}
+
+// expectAssignable reports msg at expr unless a value of type from can be
+// stored as type to
+func (c *Checker) expectAssignable(expr Expr, to, from Type, msg string) {
+	if to == invalidType || from == invalidType || assignable(to, from) {
+		return
+	}
+	c.errorAt(expr, msg)
+}
+
+func (c *Checker) expectCondition(cond Expr) {
+	if typ := c.checkExpr(cond); typ != BoolType && typ != invalidType {
+		c.errorAt(cond, fmt.Sprintf("Expected a bool condition, got %v", typ))
+	}
+}
+
+// checkExprAs is checkExpr for a value stored as type want, which gives an
+// array or map literal the type it is stored as when its items fit it. An
+// empty literal can't infer one on its own, and `[1]` stored as `[float]`
+// widens its items like `let f: float = 1` does
+func (c *Checker) checkExprAs(expr Expr, want Type) Type {
+	var typ Type
+	switch e := expr.(type) {
+	case *Array:
+		typ = c.checkArray(e, want)
+	case *MapLit:
+		typ = c.checkMap(e, want)
+	default:
+		return c.checkExpr(expr)
+	}
+	c.types[expr] = typ
+	return typ
+}
+
+// checkExpr infers and records the type of an expression
+func (c *Checker) checkExpr(expr Expr) Type {
+	typ := c.inferExpr(expr)
+	if expr != nil {
+		c.types[expr] = typ
+	}
+	return typ
+}
+
+func (c *Checker) inferExpr(expr Expr) Type {
+	switch e := expr.(type) {
+	case nil:
+		return invalidType
+	case *NumLiteral:
+		return IntType
+	case *FloatLiteral:
+		return FloatType
+	case *StringLiteral:
+		return StrType
+	case *BoolLiteral:
+		return BoolType
+	case *InterpString:
+		for _, part := range e.Parts {
+			if c.checkExpr(part) == VoidType {
+				c.errorAt(part, "Cannot interpolate a void value")
+			}
+		}
+		return StrType
+	case *Ident:
+		return c.lookup(e)
+	case *FuncArg:
+		return c.checkExpr(e.Value)
+	case *CallExpr:
+		if e.Builtin != nil {
+			return c.checkExpr(e.Builtin)
+		}
+		return c.checkCall(e)
+	case *InputIntCall:
+		c.expectPrompt(e.Input)
+		return IntType
+	case *InputStrCall:
+		c.expectPrompt(e.Input)
+		return StrType
+	case *UnaryExpr:
+		return c.checkUnary(e)
+	case *BinaryExpr:
+		return c.checkBinary(e)
+	case *Array:
+		return c.checkArray(e, nil)
+	case *IndexExpr:
+		return c.checkIndex(e.Target, e.Index)
+	case *MapLit:
+		return c.checkMap(e, nil)
+	case *Lambda:
+		return c.checkLambda(e)
+	case *HasCall:
+		c.checkKey(e.Map, e.Key, "has")
+		return BoolType
+	case *KeysCall:
+		if m, ok := c.checkMapArg(e.Map, "keys"); ok {
+			return &ArrayType{Elem: m.Key}
+		}
+		return invalidType
+	case *DeleteCall:
+		c.checkKey(e.Map, e.Key, "delete")
+		return VoidType
+	case *StructLit:
+		return c.checkStructLit(e)
+	case *FieldExpr:
+		return c.checkField(e, e.Target, e.Field)
+	case *LenCall:
+		typ := c.checkExpr(e.Value)
+		if _, isMap := typ.(*MapType); isMap || typ == invalidType || typ == StrType || isArray(typ) {
+			return IntType
+		}
+		c.errorAt(e.Value, fmt.Sprintf("len is not defined for %v", typ))
+		return IntType
+	case *IfExpr:
+		c.expectCondition(e.Condition)
+		then, els := c.checkExpr(e.Then), c.checkExpr(e.Else)
+		if then == invalidType || els == invalidType {
+			return invalidType
+		}
+		if !sameType(then, els) {
+			c.errorAt(e, fmt.Sprintf("Both arms of an if expression must have the same type, got %v and %v", then, els))
+			return invalidType
+		}
+		return then
+	default:
+		c.errorAt(expr, fmt.Sprintf("Unexpected expression %T", expr))
+		return invalidType
+	}
+}
+
+func (c *Checker) expectPrompt(prompt Expr) {
+	if typ := c.checkExpr(prompt); typ != StrType && typ != invalidType {
+		c.errorAt(prompt, fmt.Sprintf("Expected a str prompt, got %v", typ))
+	}
+}
+
+// checkArray infers `[int]` from the items of an array literal, mixing ints
+// and floats makes an array of floats. It is want instead if the items fit it
+func (c *Checker) checkArray(arr *Array, want Type) Type {
+	wantArr, _ := want.(*ArrayType)
+	if len(arr.Items) == 0 {
+		if wantArr != nil {
+			return want
+		}
+		c.errorAt(arr, "Cannot infer the type of an empty array, annotate it e.g. let a: [int] = []")
+		return invalidType
+	}
+	var wantElem Type
+	if wantArr != nil {
+		wantElem = wantArr.Elem
+	}
+	elem := c.unify(arr.Items, wantElem, "Array items")
+	if elem == invalidType {
+		return invalidType
+	}
+	if elem == VoidType {
+		c.errorAt(arr, "Arrays can't hold void")
+		return invalidType
+	}
+	if wantArr != nil && assignable(wantElem, elem) {
+		return want
+	}
+	return &ArrayType{Elem: elem}
+}
+
+// unify is the common type of exprs, which must all have the same type
+// except that mixing ints and floats makes floats. Each is checked as want,
+// nil if nothing is expected of them
+func (c *Checker) unify(exprs []Expr, want Type, what string) Type {
+	common := c.checkExprAs(exprs[0], want)
+	for _, expr := range exprs[1:] {
+		typ := c.checkExprAs(expr, want)
+		switch {
+		case common == invalidType || typ == invalidType:
+			common = invalidType
+		case isNumeric(common) && isNumeric(typ) && !sameType(common, typ):
+			common = FloatType
+		case !sameType(common, typ):
+			c.errorAt(expr, fmt.Sprintf("%s must have the same type, got %v and %v", what, common, typ))
+			common = invalidType
+		}
+	}
+	return common
+}
+
+// checkMap infers `map[str]int` from the entries of a map literal, or is
+// want if the entries fit it
+func (c *Checker) checkMap(m *MapLit, want Type) Type {
+	wantMap, _ := want.(*MapType)
+	if len(m.Keys) == 0 {
+		if wantMap != nil {
+			return want
+		}
+		c.errorAt(m, "Cannot infer the type of an empty map, annotate it e.g. let m: map[str]int = {}")
+		return invalidType
+	}
+	var wantKey, wantValue Type
+	if wantMap != nil {
+		wantKey, wantValue = wantMap.Key, wantMap.Value
+	}
+	key, value := c.unify(m.Keys, wantKey, "Map keys"), c.unify(m.Values, wantValue, "Map values")
+	if key == invalidType || value == invalidType {
+		return invalidType
+	}
+	if !isHashable(key) {
+		c.errorAt(m.Keys[0], fmt.Sprintf("Map keys must be int, str or bool, got %v", key))
+		return invalidType
+	}
+	if value == VoidType {
+		c.errorAt(m, "Maps can't hold void")
+		return invalidType
+	}
+	if wantMap != nil && sameType(wantKey, key) && assignable(wantValue, value) {
+		return want
+	}
+	return &MapType{Key: key, Value: value}
+}
+
+// checkMapArg expects the map argument of the builtin fn
+func (c *Checker) checkMapArg(arg Expr, fn string) (*MapType, bool) {
+	typ := c.checkExpr(arg)
+	m, ok := typ.(*MapType)
+	if !ok && typ != invalidType {
+		c.errorAt(arg, fmt.Sprintf("%s is not defined for %v", fn, typ))
+	}
+	return m, ok
+}
+
+// checkKey expects key to be a key of the map argument of the builtin fn
+func (c *Checker) checkKey(arg, key Expr, fn string) {
+	m, ok := c.checkMapArg(arg, fn)
+	typ := c.checkExpr(key)
+	if ok && typ != invalidType && !sameType(m.Key, typ) {
+		c.errorAt(key, fmt.Sprintf("Map key must be %v, got %v", m.Key, typ))
+	}
+}
+
+// checkStructLit expects every field of the struct to be set exactly once
+func (c *Checker) checkStructLit(lit *StructLit) Type {
+	st, ok := c.structNamed(lit.Name.Name)
+	if !ok {
+		for _, field := range lit.Fields {
+			c.checkExpr(field.Value)
+		}
+		c.errorAt(lit, fmt.Sprintf("Unknown struct %s", lit.Name.Name))
+		return invalidType
+	}
+	set := make(map[string]bool)
+	for _, field := range lit.Fields {
+		_, fieldType := st.field(field.Name)
+		switch {
+		case fieldType == nil:
+			c.checkExpr(field.Value)
+			c.errorAt(field.Value, fmt.Sprintf("%s has no field %s", st.Name, field.Name))
+			continue
+		case set[field.Name]:
+			c.errorAt(field.Value, fmt.Sprintf("Field %s is set twice", field.Name))
+		}
+		set[field.Name] = true
+		typ := c.checkExprAs(field.Value, fieldType)
+		c.expectAssignable(field.Value, fieldType, typ, fmt.Sprintf("Field %s of %s has type %v, got %v", field.Name, st.Name, fieldType, typ))
+	}
+	missing := []string{}
+	for _, field := range st.Fields {
+		if !set[field.Name] {
+			missing = append(missing, field.Name)
+		}
+	}
+	if len(missing) > 0 {
+		c.errorAt(lit, fmt.Sprintf("%s literal is missing %s", st.Name, strings.Join(missing, ", ")))
+	}
+	return st
+}
+
+// checkField is the type of target.field, node is reported if there is no such field
+func (c *Checker) checkField(node Node, target Expr, field string) Type {
+	typ := c.checkExpr(target)
+	if typ == invalidType {
+		return invalidType
+	}
+	st, ok := typ.(*StructType)
+	if !ok {
+		c.errorAt(target, fmt.Sprintf("Cannot access field %s of %v", field, typ))
+		return invalidType
+	}
+	_, fieldType := st.field(field)
+	if fieldType == nil {
+		c.errorAt(node, fmt.Sprintf("%s has no field %s", st.Name, field))
+		return invalidType
+	}
+	return fieldType
+}
+
+// checkIndex is the element type of target[index], or the value type if
+// target is a map
+func (c *Checker) checkIndex(target, index Expr) Type {
+	typ := c.checkExpr(target)
+	if m, ok := typ.(*MapType); ok {
+		if key := c.checkExpr(index); key != invalidType && !sameType(m.Key, key) {
+			c.errorAt(index, fmt.Sprintf("Map key must be %v, got %v", m.Key, key))
+		}
+		return m.Value
+	}
+	if idx := c.checkExpr(index); idx != IntType && idx != invalidType {
+		c.errorAt(index, fmt.Sprintf("Array index must be an int, got %v", idx))
+	}
+	if typ == invalidType {
+		return invalidType
+	}
+	arr, ok := typ.(*ArrayType)
+	if !ok {
+		c.errorAt(target, fmt.Sprintf("Cannot index %v", typ))
+		return invalidType
+	}
+	return arr.Elem
+}
+
+// checkLambda checks the body of a lambda where it is written, so it sees
+// the types of the variables it captures
+func (c *Checker) checkLambda(lambda *Lambda) Type {
+	sig := &FuncType{Params: make([]Type, len(lambda.Params)), Ret: c.resolveType(lambda.RetType, lambda)}
+	for i, param := range lambda.Params {
+		if param.Type == nil || param.Type == VoidType {
+			c.errorAt(&lambda.Params[i], fmt.Sprintf("Parameter %s needs a type", param.Name))
+			sig.Params[i] = invalidType
+			continue
+		}
+		sig.Params[i] = c.resolveType(param.Type, &lambda.Params[i])
+	}
+	c.checkBody(lambda, lambda.Params, lambda.Body, sig)
+	return sig
+}
+
+func (c *Checker) checkCall(call *CallExpr) Type {
+	name := call.Function.Name
+	var typ Type
+	var sig *FuncType
+	var paramNames []string
+	if call.Callee != nil {
+		name = "the function"
+		typ = c.checkExpr(call.Callee)
+	} else if sym, ok := c.ast.Symbols[call]; !ok {
+		for _, arg := range call.Args.Args {
+			c.checkExpr(arg.Value)
+		}
+		return invalidType
+	} else if fn, isFunc := sym.Decl.(*FuncDef); isFunc && sym.Kind == FuncSymbol {
+		sig = c.declareFunc(fn)
+		for _, param := range fn.Params {
+			paramNames = append(paramNames, param.Name)
+		}
+	} else {
+		typ = c.lookup(call)
+	}
+	if sig == nil {
+		// a function value has no parameter names, they are numbered instead
+		var ok bool
+		if sig, ok = typ.(*FuncType); !ok {
+			for _, arg := range call.Args.Args {
+				c.checkExpr(arg.Value)
+			}
+			switch {
+			case typ == invalidType:
+			case call.Callee != nil:
+				c.errorAt(call, fmt.Sprintf("Cannot call a value of type %v", typ))
+			default:
+				c.errorAt(call, fmt.Sprintf("Cannot call %s, which has type %v", name, typ))
+			}
+			return invalidType
+		}
+		for i := range sig.Params {
+			paramNames = append(paramNames, fmt.Sprint(i+1))
+		}
+	}
+	argTypes := make([]Type, len(call.Args.Args))
+	for i, arg := range call.Args.Args {
+		var want Type
+		if i < len(sig.Params) {
+			want = sig.Params[i]
+		}
+		argTypes[i] = c.checkExprAs(arg.Value, want)
+	}
+	if len(call.Args.Args) != len(sig.Params) {
+		c.errorAt(call, fmt.Sprintf("%s expects %s, got %d", name, plural(len(sig.Params), "argument"), len(call.Args.Args)))
+		return sig.Ret
+	}
+	for i, paramType := range sig.Params {
+		c.expectAssignable(call.Args.Args[i].Value, paramType, argTypes[i],
+			fmt.Sprintf("Argument %s of %s has type %v, got %v", paramNames[i], name, paramType, argTypes[i]))
+	}
+	return sig.Ret
+}
+
+// plural formats a count of things, e.g. "1 argument" or "2 arguments"
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func (c *Checker) checkUnary(e *UnaryExpr) Type {
+	operand := c.checkExpr(e.Operand)
+	if operand == invalidType {
+		return invalidType
+	}
+	switch e.Operator {
+	case Minus:
+		if isNumeric(operand) {
+			return operand
+		}
+	case BitNot:
+		if operand == IntType {
+			return IntType
+		}
+	case Not, Bang:
+		if operand == BoolType {
+			return BoolType
+		}
+	}
+	c.errorAt(e, fmt.Sprintf("Operator %s is not defined for %v", opSymbol(e.Operator), operand))
+	return invalidType
+}
+
+func (c *Checker) checkBinary(e *BinaryExpr) Type {
+	left, right := c.checkExpr(e.Left), c.checkExpr(e.Right)
+	if left == invalidType || right == invalidType {
+		return invalidType
+	}
+	numeric := isNumeric(left) && isNumeric(right)
+	switch e.Operator {
+	case And, Or:
+		if left == BoolType && right == BoolType {
+			return BoolType
+		}
+	case EqEq, Neq:
+		if numeric || (sameType(left, right) && left != VoidType && !isReference(left)) {
+			return BoolType
+		}
+	case Gt, Gte, Lt, Lte:
+		if numeric {
+			return BoolType
+		}
+	case Plus, Minus, Mul, Div, Mod:
+		if numeric {
+			if left == FloatType || right == FloatType {
+				return FloatType
+			}
+			return IntType
+		}
+		if e.Operator == Plus && left == StrType && right == StrType {
+			return StrType
+		}
+	case BitAnd, BitOr, BitXor, LShift, RShift:
+		if left == IntType && right == IntType {
+			return IntType
+		}
+	}
+	c.errorAt(e, fmt.Sprintf("Operator %s is not defined for %v and %v", opSymbol(e.Operator), left, right))
+	return invalidType
+}
+
+// opSymbol is how an operator is written in the source, for error messages
+func opSymbol(op tokenKind) string {
+	switch op {
+	case Plus:
+		return "+"
+	case Minus:
+		return "-"
+	case Mul:
+		return "*"
+	case Div:
+		return "/"
+	case Mod:
+		return "%"
+	case EqEq:
+		return "=="
+	case Neq:
+		return "!="
+	case Gt:
+		return ">"
+	case Gte:
+		return ">="
+	case Lt:
+		return "<"
+	case Lte:
+		return "<="
+	case And:
+		return "and"
+	case Or:
+		return "or"
+	case Not:
+		return "not"
+	case Bang:
+		return "!"
+	case BitAnd:
+		return "&"
+	case BitOr:
+		return "|"
+	case BitXor:
+		return "^"
+	case BitNot:
+		return "~"
+	case LShift:
+		return "<<"
+	case RShift:
+		return ">>"
+	default:
+		return op.ToString()
+	}
+}
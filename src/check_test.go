@@ -0,0 +1,133 @@
+package src
+
+import (
+	"testing"
+)
+
+// typeErrors is what the Checker reports for src, which must resolve cleanly
+func typeErrors(t *testing.T, src string) []TypeError {
+	t.Helper()
+	res := frontend(t, NewInputLexer(src))
+	errs := []TypeError{}
+	for _, err := range res.errs {
+		typeErr, ok := err.(TypeError)
+		if !ok {
+			t.Fatal(res.loader.Print(err))
+		}
+		errs = append(errs, typeErr)
+	}
+	return errs
+}
+
+func TestCheckerErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		line int
+		col  int
+		msg  string
+	}{
+		{"add int and str", `let x = 1 + "a"`, 1, 9, "Operator + is not defined for int and str"},
+		{"int condition", "if 1 {\n\tprint(1)\n}", 1, 4, "Expected a bool condition, got int"},
+		{"typed let", `let s: str = 3`, 1, 14, "Cannot assign int to s, which is declared as str"},
+		{"reassign", "let b = true\nb = 2", 2, 5, "Cannot assign int to b, which has type bool"},
+		{"not an int", `print(!1)`, 1, 7, "Operator ! is not defined for int"},
+		{"if arms", `let v = if true then 1 else "one"`, 1, 9, "Both arms of an if expression must have the same type, got int and str"},
+		{"return type", "def f() -> str {\n\treturn 1\n}", 2, 9, "Expected return type str, got int"},
+		{"missing return", "def f(n: int) -> int {\n\tif n > 0 {\n\t\treturn 1\n\t}\n}", 1, 1, "Missing return, the body can end without returning int"},
+		{"missing else", "def f(n: int) -> int {\n\tif n > 0 {\n\t\treturn 1\n\t} else if n < 0 {\n\t\treturn -1\n\t}\n}", 1, 1, "Missing return, the body can end without returning int"},
+		{"lambda missing return", "let f = fn() -> str {\n\tprint(1)\n}", 1, 9, "Missing return, the body can end without returning str"},
+		{"loop may not run", "def f(n: int) -> int {\n\twhile n > 0 {\n\t\treturn n\n\t}\n}", 1, 1, "Missing return, the body can end without returning int"},
+		{"bare return", "def f() -> int {\n\treturn\n}", 2, 2, "Expected a return value of type int"},
+		{"return outside", `return 1`, 1, 1, "return outside of a function"},
+		{"argument type", "def f(n: int) -> int {\n\treturn n\n}\nf(true)", 4, 3, "Argument n of f has type int, got bool"},
+		{"arity", "def f(n: int) -> int {\n\treturn n\n}\nf(1, 2)", 4, 1, "f expects 1 argument, got 2"},
+		{"too few", "def f(a: int, b: int) -> int {\n\treturn a + b\n}\nf(1)", 4, 1, "f expects 2 arguments, got 1"},
+		{"no arguments", "def f() -> int {\n\treturn 1\n}\nf(1)", 4, 1, "f expects 0 arguments, got 1"},
+		{"call before definition", "f(\"one\")\ndef f(n: int) -> int {\n\treturn n\n}", 1, 3, "Argument n of f has type int, got str"},
+		{"void value", "def f() -> void {\n\tprint(1)\n}\nlet x = f()", 4, 9, "Cannot assign a void value to x"},
+		{"bitwise on float", `print(1.5 & 1)`, 1, 7, "Operator & is not defined for float and int"},
+		{"array items", `let a = [1, "two"]`, 1, 13, "Array items must have the same type, got int and str"},
+		{"empty array", `let a = []`, 1, 9, "Cannot infer the type of an empty array, annotate it e.g. let a: [int] = []"},
+		{"index type", "let a = [1]\nprint(a[true])", 2, 9, "Array index must be an int, got bool"},
+		{"index scalar", "let n = 1\nprint(n[0])", 2, 7, "Cannot index int"},
+		{"element type", "let a = [1]\na[0] = \"one\"", 2, 8, "Cannot assign str to an element of [int]"},
+		{"array annotation", `let a: [str] = [1]`, 1, 16, "Cannot assign [int] to a, which is declared as [str]"},
+		{"narrowing array annotation", `let a: [int] = [1.5]`, 1, 16, "Cannot assign [float] to a, which is declared as [int]"},
+		{"map annotation", `let m: map[str]float = {1: 2}`, 1, 24, "Cannot assign map[int]int to m, which is declared as map[str]float"},
+		{"len of int", `print(len(1))`, 1, 11, "len is not defined for int"},
+		{"compare arrays", "let a = [1]\nprint(a == a)", 2, 7, "Operator == is not defined for [int] and [int]"},
+		{"unknown field", "struct P { x: int }\nlet p = P { x: 1 }\nprint(p.y)", 3, 7, "P has no field y"},
+		{"missing field", "struct P { x: int, y: int }\nlet p = P { x: 1 }", 2, 9, "P literal is missing y"},
+		{"field type", "struct P { x: int }\nlet p = P { x: \"one\" }", 2, 16, "Field x of P has type int, got str"},
+		{"field set twice", "struct P { x: int }\nlet p = P { x: 1, x: 2 }", 2, 22, "Field x is set twice"},
+		{"assign field", "struct P { x: int }\nlet p = P { x: 1 }\np.x = true", 3, 7, "Cannot assign bool to x, which has type int"},
+		{"field of int", "let n = 1\nprint(n.x)", 2, 7, "Cannot access field x of int"},
+		{"unknown struct", `let p = Q { x: 1 }`, 1, 9, "Unknown struct Q"},
+		{"unknown type", "def f(q: Q) -> int {\n\treturn 1\n}", 1, 7, "Unknown type Q"},
+		{"nested struct", "def f() -> int {\n\tstruct P { x: int }\n\treturn 1\n}", 2, 2, "Structs can only be declared at the top level"},
+		{"redeclared struct", "struct P { x: int }\nstruct P { y: int }", 2, 1, "Struct P is already declared"},
+		{"compare structs", "struct P { x: int }\nlet p = P { x: 1 }\nprint(p == p)", 3, 7, "Operator == is not defined for P and P"},
+		{"map values", `let m = {"a": 1, "b": "two"}`, 1, 23, "Map values must have the same type, got int and str"},
+		{"empty map", `let m = {}`, 1, 9, "Cannot infer the type of an empty map, annotate it e.g. let m: map[str]int = {}"},
+		{"float keys", `let m = {1.5: 1}`, 1, 10, "Map keys must be int, str or bool, got float"},
+		{"key annotation", "def f(m: map[[int]]int) -> int {\n\treturn 1\n}", 1, 7, "Map keys must be int, str or bool, got [int]"},
+		{"map key", "let m = {\"a\": 1}\nprint(m[0])", 2, 9, "Map key must be str, got int"},
+		{"map value", "let m = {\"a\": 1}\nm[\"b\"] = true", 2, 10, "Cannot assign bool to an element of map[str]int"},
+		{"has key", "let m = {\"a\": 1}\nprint(has(m, 1))", 2, 14, "Map key must be str, got int"},
+		{"keys of array", `print(keys([1]))`, 1, 12, "keys is not defined for [int]"},
+		{"call a variable", "let f = 1\nprint(f())", 2, 7, "Cannot call f, which has type int"},
+		{"call a value", "let xs = [1]\nprint(xs[0](2))", 2, 7, "Cannot call a value of type int"},
+		{"call a result", "let fs = [fn(n: int) -> int { return n }]\nprint(fs[0]())", 2, 7, "the function expects 1 argument, got 0"},
+		{"lambda argument", "let f = fn(n: int) -> int { return n }\nprint(f(true))", 2, 9, "Argument 1 of f has type int, got bool"},
+		{"lambda arity", "let f = fn(n: int) -> int { return n }\nprint(f())", 2, 7, "f expects 1 argument, got 0"},
+		{"lambda return", "let f = fn() -> int {\n\treturn \"one\"\n}", 2, 9, "Expected return type int, got str"},
+		{"lambda parameter", `let f = fn(n) -> int { return 1 }`, 1, 12, "Parameter n needs a type"},
+		{"function value", "def f(n: int) -> int {\n\treturn n\n}\nlet g: fn(str) -> int = f", 4, 25, "Cannot assign fn(int) -> int to g, which is declared as fn(str) -> int"},
+		{"compare functions", "def f() -> int {\n\treturn 1\n}\nprint(f == f)", 4, 7, "Operator == is not defined for fn() -> int and fn() -> int"},
+		{"loop annotation", "for (let s: str = 1; len(s) < 2; s = s + \"a\") {\n\tprint(s)\n}", 1, 19, "Cannot assign int to s, which is declared as str"},
+		{"iterate int", "for n in 3 {\n\tprint(n)\n}", 1, 10, "Cannot iterate over int"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := typeErrors(t, tt.src)
+			if len(errs) != 1 {
+				t.Fatalf("expected 1 error, got %d: %v", len(errs), errs)
+			}
+			err := errs[0]
+			if err.Msg != tt.msg || err.Span.Line() != tt.line || err.Span.Col() != tt.col {
+				t.Errorf("got %v: %q, want %d:%d: %q", err.Span, err.Msg, tt.line, tt.col, tt.msg)
+			}
+		})
+	}
+}
+
+func TestCheckerReportsEveryError(t *testing.T) {
+	errs := typeErrors(t, `
+let a = 1 + "a"
+let b = a * 2
+let c: bool = 1
+print(-true)
+`)
+	// `a` is invalid, so `a * 2` is not reported again
+	if len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %d: %v", len(errs), errs)
+	}
+}
+
+func TestCheckerTypesEveryExpr(t *testing.T) {
+	ast := check(t, NewInputLexer(`
+let f = 1.5
+let n = 2
+let x = f * n
+let s = "n is {n}"
+let b = n > 1 and not (f < 0.5)
+`))
+	want := []Type{FloatType, IntType, FloatType, StrType, BoolType}
+	for i, stmt := range ast.Root.(*Program).Statements {
+		let := stmt.(*LetExpr)
+		if got := ast.Types[let.Value]; got != want[i] {
+			t.Errorf("%s: got %v, want %v", let.Variable.Name, got, want[i])
+		}
+	}
+}
@@ -14,8 +14,32 @@ type BytecodeEmitter struct {
 	Instructions   []Instruction
 	register       int
 	labelCounter   int
-	varRegisterMap map[string]int
-	funcMap        map[string]string // ident name to label
+	varRegisterMap map[string]int   // temporaries
+	symbols        map[Node]*Symbol // from the Resolver
+	symRegisters   map[*Symbol]int
+	varTypes       map[*Symbol]Type
+	funcMap        map[*Symbol]string // function to label
+	funcTypes      map[*Symbol]Type
+	funcParams     map[*Symbol][]FnParam
+	types          map[Expr]Type // from the Checker
+	retType        Type          // of the function being compiled
+	frame          *frame        // of the function being compiled, nil at the top level
+	captures       map[*Lambda][]*Symbol
+	lambdas        []*Lambda // waiting to be compiled after the functions
+	lambdaLabels   map[*Lambda]string
+	// evaluated are the registers of the target of an assignment being
+	// compiled, `a[f()] += 1` reads through the same nodes it writes to
+	// and must only call f once
+	evaluated map[Expr]int
+}
+
+// frame is the range of registers a function owns, starting at start and
+// ending wherever allocation got to once its body is compiled. Each call the
+// function makes saves the range on the data stack and restores it after, so
+// a recursive call can't clobber the caller's locals
+type frame struct {
+	start   int
+	patches []int // SAVE and RESTORE instructions waiting for the end of the range
 }
 
 func NewBytecodeEmitter() *BytecodeEmitter {
@@ -24,17 +48,37 @@ func NewBytecodeEmitter() *BytecodeEmitter {
 		register:       1,
 		labelCounter:   0,
 		varRegisterMap: make(map[string]int),
-		funcMap:        make(map[string]string),
+		symbols:        make(map[Node]*Symbol),
+		symRegisters:   make(map[*Symbol]int),
+		varTypes:       make(map[*Symbol]Type),
+		funcMap:        make(map[*Symbol]string),
+		funcTypes:      make(map[*Symbol]Type),
+		funcParams:     make(map[*Symbol][]FnParam),
+		types:          make(map[Expr]Type),
+		captures:       make(map[*Lambda][]*Symbol),
+		lambdaLabels:   make(map[*Lambda]string),
+		evaluated:      make(map[Expr]int),
 	}
 }
 
 func (be *BytecodeEmitter) Walk(ast *AST) {
 	mainLabel := "__begin%"
+	if ast.Types != nil {
+		be.types = ast.Types
+	}
+	if ast.Symbols != nil {
+		be.symbols = ast.Symbols
+	}
+	if ast.Captures != nil {
+		be.captures = ast.Captures
+	}
 
 	for _, stmt := range ast.Root.(*Program).Statements {
 		if fn, ok := stmt.(*FuncDef); ok {
-			funcLabel := "__func%_" + fn.Name.Name
-			be.funcMap[fn.Name.Name] = funcLabel
+			sym := be.symbols[fn]
+			be.funcMap[sym] = labelOf(sym)
+			be.funcTypes[sym] = fn.RetType
+			be.funcParams[sym] = slices.Clone(fn.Params)
 		}
 	}
 	be.EmitLabel(mainLabel)
@@ -49,6 +93,12 @@ func (be *BytecodeEmitter) Walk(ast *AST) {
 			be.Visit(fn)
 		}
 	}
+	// compiling a lambda can queue the lambdas inside it
+	for len(be.lambdas) > 0 {
+		lambda := be.lambdas[0]
+		be.lambdas = be.lambdas[1:]
+		be.compileLambda(lambda)
+	}
 }
 
 func (be *BytecodeEmitter) OutputToFile(file string) error {
@@ -89,7 +139,7 @@ func (be *BytecodeEmitter) PrintBytecode() {
 	}
 }
 
-var maxRegisters = 100
+const maxRegisters = 1024
 
 type Instruction struct {
 	Opcode Opcode
@@ -135,6 +185,39 @@ const (
 	INPUT
 	INPUTSTR
 	HALT
+	TOSTR
+	ITOF
+	FADD
+	FSUB
+	FMUL
+	FDIV
+	FMOD
+	FJEQ
+	FJNE
+	FJGT
+	FJLT
+	FJGE
+	FJLE
+	NEG
+	FNEG
+	SAVE
+	RESTORE
+	NEWARR
+	INDEX
+	SETIDX
+	LEN
+	NEWOBJ
+	GETFIELD
+	SETFIELD
+	CLOSURE
+	CALLV
+	NEWCELL
+	GETCELL
+	SETCELL
+	NEWMAP
+	HAS
+	KEYS
+	DELETE
 )
 
 func (oc Opcode) String() string {
@@ -182,6 +265,39 @@ var opMap = map[Opcode]string{
 	INPUTSTR: "INPUTSTR",
 	HALT:     "HALT",
 	LABEL:    "LABEL",
+	TOSTR:    "TOSTR",
+	ITOF:     "ITOF",
+	FADD:     "FADD",
+	FSUB:     "FSUB",
+	FMUL:     "FMUL",
+	FDIV:     "FDIV",
+	FMOD:     "FMOD",
+	FJEQ:     "FJEQ",
+	FJNE:     "FJNE",
+	FJGT:     "FJGT",
+	FJLT:     "FJLT",
+	FJGE:     "FJGE",
+	FJLE:     "FJLE",
+	NEG:      "NEG",
+	FNEG:     "FNEG",
+	SAVE:     "SAVE",
+	RESTORE:  "RESTORE",
+	NEWARR:   "NEWARR",
+	INDEX:    "INDEX",
+	SETIDX:   "SETIDX",
+	LEN:      "LEN",
+	NEWOBJ:   "NEWOBJ",
+	GETFIELD: "GETFIELD",
+	SETFIELD: "SETFIELD",
+	CLOSURE:  "CLOSURE",
+	CALLV:    "CALLV",
+	NEWCELL:  "NEWCELL",
+	GETCELL:  "GETCELL",
+	SETCELL:  "SETCELL",
+	NEWMAP:   "NEWMAP",
+	HAS:      "HAS",
+	KEYS:     "KEYS",
+	DELETE:   "DELETE",
 }
 
 var opcodeMap = map[tokenKind]Opcode{
@@ -190,11 +306,9 @@ var opcodeMap = map[tokenKind]Opcode{
 	Mul:    MUL,
 	Div:    DIV,
 	Mod:    MOD,
-	Not:    NOT,
 	BitAnd: BAND,
 	BitOr:  BOR,
 	BitXor: BXOR,
-	BitNot: BNOT,
 	LShift: LSHIFT,
 	RShift: RSHIFT,
 	Return: RET,
@@ -207,6 +321,29 @@ var opcodeMap = map[tokenKind]Opcode{
 	Neq:    JNE,
 }
 
+// unaryOpcodeMap holds the single operand opcodes for the prefix operators
+var unaryOpcodeMap = map[tokenKind]Opcode{
+	Minus:  NEG,
+	Not:    NOT,
+	Bang:   NOT,
+	BitNot: BNOT,
+}
+
+// floatOpcodeMap is used instead of opcodeMap when either operand is a float
+var floatOpcodeMap = map[tokenKind]Opcode{
+	Plus:  FADD,
+	Minus: FSUB,
+	Mul:   FMUL,
+	Div:   FDIV,
+	Mod:   FMOD,
+	EqEq:  FJEQ,
+	Neq:   FJNE,
+	Gt:    FJGT,
+	Lt:    FJLT,
+	Gte:   FJGE,
+	Lte:   FJLE,
+}
+
 func (be *BytecodeEmitter) Emit(opcode Opcode, args ...interface{}) {
 	be.Instructions = append(be.Instructions, Instruction{Opcode: opcode, Args: args})
 }
@@ -230,6 +367,54 @@ func (be *BytecodeEmitter) allocTemp(reg int) int {
 	return be.AllocateRegister(fmt.Sprintf("temp%d", reg))
 }
 
+// symbolRegister is the register of the variable that node declares or refers
+// to, every declaration gets its own so a variable never aliases another
+func (be *BytecodeEmitter) symbolRegister(node Node) int {
+	sym, ok := be.symbols[node]
+	if !ok {
+		panic(fmt.Sprintf("Unresolved name in %T", node))
+	}
+	if reg, ok := be.symRegisters[sym]; ok {
+		return reg
+	}
+	reg := be.allocTemp(be.register)
+	be.symRegisters[sym] = reg
+	return reg
+}
+
+// define stores the first value of the variable node declares. A variable a
+// lambda captures lives in a cell on the heap instead, so the lambda and the
+// function it came from see each other's assignments
+func (be *BytecodeEmitter) define(node Node, valueReg int) {
+	reg := be.symbolRegister(node)
+	if be.symbols[node].captured {
+		be.Emit(NEWCELL, valueReg, reg)
+		return
+	}
+	be.Emit(MOV, Register(valueReg), reg)
+}
+
+// assign stores a new value in the variable node refers to
+func (be *BytecodeEmitter) assign(node Node, valueReg int) {
+	reg := be.symbolRegister(node)
+	if be.symbols[node].captured {
+		be.Emit(SETCELL, reg, valueReg)
+		return
+	}
+	be.Emit(MOV, Register(valueReg), reg)
+}
+
+// load is the register holding the value of the variable node refers to
+func (be *BytecodeEmitter) load(node Node) int {
+	reg := be.symbolRegister(node)
+	if !be.symbols[node].captured {
+		return reg
+	}
+	tmp := be.allocTemp(be.register)
+	be.Emit(GETCELL, reg, tmp)
+	return tmp
+}
+
 func (be *BytecodeEmitter) NewLabel() string {
 	be.labelCounter++
 	return fmt.Sprintf("0x%x", be.labelCounter)
@@ -244,35 +429,61 @@ func (be *BytecodeEmitter) Visit(node Node) {
 	case nil:
 		return
 	case *FuncDef:
-		n.Print()
-		funcLabel := "__func%_" + n.Name.Name
-		be.EmitLabel(funcLabel)
-		be.funcMap[n.Name.Name] = funcLabel
-		slices.Reverse(n.Params)
-		for _, param := range n.Params {
-			reg := be.AllocateRegister(param.Name)
-			be.Emit(POP, reg)
-			be.varRegisterMap[param.Name] = reg
-		}
-		hasRet := false
-		for _, stmt := range n.Body.Statements {
-			switch st := stmt.(type) {
-			case *ReturnExpr:
-				hasRet = true
-				valReg := be.CompileExpr(st.Value, false)
-				be.Emit(MOV, Register(valReg), RAX)
-				be.Emit(RET)
-				return
-			default:
-				be.Visit(st)
+		be.compileFunc(n)
+	case *CallExpr:
+		if _, ok := n.Builtin.(*DeleteCall); ok {
+			be.Visit(n.Builtin)
+			return
+		}
+		_ = be.CompileExpr(n, false)
+	case *Block:
+		// the Resolver already gave the block's variables registers of their own
+		for _, stmt := range n.Statements {
+			be.Visit(stmt)
+		}
+	case *WhileLoop:
+		startLabel := be.NewLabel()
+		endLabel := be.NewLabel()
+		be.EmitLabel(startLabel)
+		cond := be.CompileExpr(n.Condition, true)
+		be.Emit(JNT, cond, endLabel)
+		for _, stmt := range n.Body.(*Block).Statements {
+			be.Visit(stmt)
+		}
+		be.Emit(JMP, startLabel)
+		be.EmitLabel(endLabel)
+	case *ForLoop:
+		ident := n.Var.(*Ident)
+		startReg := be.CompileExpr(n.Start, false)
+		if n.IsDecl {
+			typ := n.Type
+			if typ == nil {
+				typ = be.typeOf(n.Start)
+			} else if typ == FloatType {
+				startReg = be.toFloat(n.Start, startReg)
 			}
+			be.varTypes[be.symbols[n]] = typ
+		} else if be.varTypes[be.symbols[ident]] == FloatType {
+			startReg = be.toFloat(n.Start, startReg)
 		}
-		if !hasRet {
-			be.Emit(LOAD, &LitValue{0}, RAX)
-			be.Emit(RET)
+		if n.IsDecl {
+			be.define(ident, startReg)
+		} else {
+			be.assign(ident, startReg)
 		}
-	case *CallExpr:
-		_ = be.CompileExpr(n, false)
+		startLabel := be.NewLabel()
+		endLabel := be.NewLabel()
+		be.EmitLabel(startLabel)
+		cond := be.CompileExpr(n.Condition, true)
+		be.Emit(JNT, cond, endLabel)
+		for _, stmt := range n.Body.(*Block).Statements {
+			be.Visit(stmt)
+		}
+		be.Visit(n.Step)
+		be.Emit(JMP, startLabel)
+		be.EmitLabel(endLabel)
+	case *ForIn:
+		be.compileForIn(n)
 	case *IfStmt:
 		elseLabel := be.NewLabel()
 		endLabel := be.NewLabel()
@@ -291,20 +502,197 @@ func (be *BytecodeEmitter) Visit(node Node) {
 		}
 		be.EmitLabel(endLabel)
 	case *ReturnExpr:
-		valReg := be.CompileExpr(n.Value, false)
-		be.Emit(MOV, Register(valReg), RAX)
-		be.Emit(RET)
+		be.emitReturn(n.Value)
 	case *LetExpr:
 		valueReg := be.CompileExpr(n.Value, false)
-		be.varRegisterMap[n.Variable.Name] = valueReg
+		typ := n.Type
+		if typ == nil {
+			typ = be.typeOf(n.Value)
+		} else if typ == FloatType {
+			valueReg = be.toFloat(n.Value, valueReg)
+		}
+		be.varTypes[be.symbols[n]] = typ
+		be.define(n, valueReg)
 	case *ReAssignExpr:
+		// write the new value into the variable's own register, so code that
+		// already read the variable (e.g. a loop condition) sees the change
 		valueReg := be.CompileExpr(n.NewValue, false)
-		be.varRegisterMap[n.Variable.Name] = valueReg
-		be.Emit(STORE, valueReg, n.Variable.Name)
+		if be.varTypes[be.symbols[n]] == FloatType {
+			valueReg = be.toFloat(n.NewValue, valueReg)
+		}
+		be.assign(n, valueReg)
 	case *PrintCall:
 		reg := be.CompileExpr(n.Value, false)
 		be.Emit(SYSCALL, PRINT, reg)
+	case *IndexAssign:
+		arrReg := be.CompileExpr(n.Target, false)
+		indexReg := be.CompileExpr(n.Index, false)
+		be.evaluated[n.Target], be.evaluated[n.Index] = arrReg, indexReg
+		valueReg := be.CompileExpr(n.Value, false)
+		delete(be.evaluated, n.Target)
+		delete(be.evaluated, n.Index)
+		if be.valueType(n.Target) == FloatType {
+			valueReg = be.toFloat(n.Value, valueReg)
+		}
+		be.Emit(SETIDX, arrReg, indexReg, valueReg)
+	case *FieldAssign:
+		objReg := be.CompileExpr(n.Target, false)
+		be.evaluated[n.Target] = objReg
+		valueReg := be.CompileExpr(n.Value, false)
+		delete(be.evaluated, n.Target)
+		idx, typ := be.structType(n.Target).field(n.Field)
+		if typ == FloatType {
+			valueReg = be.toFloat(n.Value, valueReg)
+		}
+		be.Emit(SETFIELD, objReg, idx, valueReg)
+	case *DeleteCall:
+		mapReg := be.CompileExpr(n.Map, false)
+		keyReg := be.CompileExpr(n.Key, false)
+		be.Emit(DELETE, mapReg, keyReg)
+	case *StructDef:
+		// only the Checker needs the declaration
+		return
+	}
+}
+
+// compileForIn walks an index over the array, or over the keys of a map
+// taken when the loop starts, so deleting inside the loop is safe
+func (be *BytecodeEmitter) compileForIn(n *ForIn) {
+	arrReg := be.CompileExpr(n.Iterable, false)
+	be.varTypes[be.symbols[n]] = be.elemType(n.Iterable)
+	if _, ok := be.typeOf(n.Iterable).(*MapType); ok {
+		keysReg := be.allocTemp(be.register)
+		be.Emit(KEYS, arrReg, keysReg)
+		arrReg = keysReg
+	}
+	lenReg := be.allocTemp(be.register)
+	be.Emit(LEN, arrReg, lenReg)
+	idxReg := be.allocTemp(be.register)
+	be.Emit(MOV, &LitValue{0}, idxReg)
+	oneReg := be.allocTemp(be.register)
+	be.Emit(MOV, &LitValue{1}, oneReg)
+	startLabel := be.NewLabel()
+	endLabel := be.NewLabel()
+	be.EmitLabel(startLabel)
+	be.Emit(JGE, idxReg, lenReg, endLabel)
+	// a fresh variable every iteration, a lambda made in the body keeps its own
+	itemReg := be.allocTemp(be.register)
+	be.Emit(INDEX, arrReg, idxReg, itemReg)
+	be.define(n.Var, itemReg)
+	for _, stmt := range n.Body.(*Block).Statements {
+		be.Visit(stmt)
+	}
+	be.Emit(ADD, idxReg, oneReg, idxReg)
+	be.Emit(JMP, startLabel)
+	be.EmitLabel(endLabel)
+}
+
+func (be *BytecodeEmitter) structType(expr Expr) *StructType {
+	st, ok := be.typeOf(expr).(*StructType)
+	if !ok {
+		panic(fmt.Sprintf("Expected a struct, got %v", be.typeOf(expr)))
+	}
+	return st
+}
+
+// elemType is the type of the items of an array expression, or of the keys
+// of a map expression
+func (be *BytecodeEmitter) elemType(expr Expr) Type {
+	switch t := be.typeOf(expr).(type) {
+	case *ArrayType:
+		return t.Elem
+	case *MapType:
+		return t.Key
+	}
+	return VoidType
+}
+
+// valueType is what indexing expr gives, the items of an array or the values of a map
+func (be *BytecodeEmitter) valueType(expr Expr) Type {
+	if m, ok := be.typeOf(expr).(*MapType); ok {
+		return m.Value
+	}
+	return be.elemType(expr)
+}
+
+// compileFunc emits a function body, with its own frame of registers
+func (be *BytecodeEmitter) compileFunc(fn *FuncDef) {
+	fn.Print()
+	sym := be.symbols[fn]
+	be.funcMap[sym] = labelOf(sym)
+	be.EmitLabel(be.funcMap[sym])
+	be.compileBody(fn.Params, fn.Body, fn.RetType, nil)
+}
+
+// compileLambda emits the body of a lambda, which starts by taking the
+// cells it captured into registers of its own
+func (be *BytecodeEmitter) compileLambda(lambda *Lambda) {
+	be.EmitLabel(be.lambdaLabels[lambda])
+	captures := be.captures[lambda]
+	outerRegs := make([]int, len(captures))
+	for i, sym := range captures {
+		outerRegs[i] = be.symRegisters[sym]
+		delete(be.symRegisters, sym)
+	}
+	be.compileBody(lambda.Params, lambda.Body, lambda.RetType, captures)
+	for i, sym := range captures {
+		be.symRegisters[sym] = outerRegs[i]
+	}
+}
+
+// compileBody emits the body of a function or lambda with its own frame of
+// registers. The caller pushed the arguments in order and CALLV pushed the
+// captured cells after them, so they pop off in reverse
+func (be *BytecodeEmitter) compileBody(params []FnParam, body *Block, retType Type, captures []*Symbol) {
+	be.retType = retType
+	outer := be.frame
+	be.frame = &frame{start: be.register}
+	for i := len(captures) - 1; i >= 0; i-- {
+		reg := be.allocTemp(be.register)
+		be.symRegisters[captures[i]] = reg
+		be.Emit(POP, reg)
+	}
+	for i := len(params) - 1; i >= 0; i-- {
+		param := &params[i]
+		reg := be.symbolRegister(param)
+		be.Emit(POP, reg)
+		if be.symbols[param].captured {
+			be.Emit(NEWCELL, reg, reg)
+		}
+		be.varTypes[be.symbols[param]] = param.Type
+	}
+	hasRet := false
+	for _, stmt := range body.Statements {
+		if ret, ok := stmt.(*ReturnExpr); ok {
+			hasRet = true
+			be.emitReturn(ret.Value)
+			break
+		}
+		be.Visit(stmt)
+	}
+	if !hasRet {
+		be.Emit(LOAD, &LitValue{0}, RAX)
+		be.Emit(RET)
+	}
+	for _, idx := range be.frame.patches {
+		be.Instructions[idx].Args[1] = be.register
+	}
+	be.frame = outer
+}
+
+// emitReturn leaves the return value in RAX, converted to the declared return type
+func (be *BytecodeEmitter) emitReturn(value Expr) {
+	if value == nil {
+		be.Emit(LOAD, &LitValue{0}, RAX)
+		be.Emit(RET)
+		return
 	}
+	valReg := be.CompileExpr(value, false)
+	if be.retType == FloatType {
+		valReg = be.toFloat(value, valReg)
+	}
+	be.Emit(MOV, Register(valReg), RAX)
+	be.Emit(RET)
 }
 
 func isConditionalOp(op tokenKind) bool {
@@ -316,7 +704,182 @@ func isConditionalOp(op tokenKind) bool {
 	}
 }
 
+// typeOf is the static type the Checker inferred for an expression, nodes
+// the Analyzer created after checking are inferred from their operands
+func (be *BytecodeEmitter) typeOf(expr Expr) Type {
+	if typ, ok := be.types[expr]; ok {
+		return typ
+	}
+	switch e := expr.(type) {
+	case *NumLiteral, *InputIntCall:
+		return IntType
+	case *FloatLiteral:
+		return FloatType
+	case *StringLiteral, *InterpString, *InputStrCall:
+		return StrType
+	case *BoolLiteral:
+		return BoolType
+	case *Ident:
+		return be.varTypes[be.symbols[e]]
+	case *FuncArg:
+		return be.typeOf(e.Value)
+	case *CallExpr:
+		if e.Builtin != nil {
+			return be.typeOf(e.Builtin)
+		}
+		if sig, ok := be.typeOf(e.Callee).(*FuncType); ok {
+			return sig.Ret
+		}
+		return be.funcTypes[be.symbols[e]]
+	case *UnaryExpr:
+		if e.Operator == Not || e.Operator == Bang {
+			return BoolType
+		}
+		return be.typeOf(e.Operand)
+	case *IfExpr:
+		return be.typeOf(e.Then)
+	case *BinaryExpr:
+		if isConditionalOp(e.Operator) || e.Operator == And || e.Operator == Or {
+			return BoolType
+		}
+		if be.typeOf(e.Left) == FloatType || be.typeOf(e.Right) == FloatType {
+			return FloatType
+		}
+		return be.typeOf(e.Left)
+	default:
+		return VoidType
+	}
+}
+
+// compileIfExpr evaluates both arms and picks one with MOV_IF when that is
+// side effect free, otherwise it branches so only the chosen arm runs
+func (be *BytecodeEmitter) compileIfExpr(e *IfExpr) int {
+	cond := be.CompileExpr(e.Condition, true)
+	if isPlainOperand(e.Then) && isPlainOperand(e.Else) {
+		thenReg := be.CompileExpr(e.Then, false)
+		elseReg := be.CompileExpr(e.Else, false)
+		resultReg := be.allocTemp(be.register)
+		be.Emit(MOV_IF, cond, thenReg, elseReg, resultReg)
+		return resultReg
+	}
+	elseLabel := be.NewLabel()
+	endLabel := be.NewLabel()
+	resultReg := be.allocTemp(be.register)
+	be.Emit(JNT, cond, elseLabel)
+	thenReg := be.CompileExpr(e.Then, false)
+	be.Emit(MOV, Register(thenReg), resultReg)
+	be.Emit(JMP, endLabel)
+	be.EmitLabel(elseLabel)
+	elseReg := be.CompileExpr(e.Else, false)
+	be.Emit(MOV, Register(elseReg), resultReg)
+	be.EmitLabel(endLabel)
+	return resultReg
+}
+
+// labelOf is the label of a named function, prefixed by the module that
+// declares it so modules can each have a private function of the same name
+func labelOf(fn *Symbol) string {
+	if fn.module == nil || fn.module.Name == "" {
+		return "__func%_" + fn.Name
+	}
+	return "__func%_" + fn.module.Name + "." + fn.Name
+}
+
+// funcLabel is the label of the function node calls or refers to
+func (be *BytecodeEmitter) funcLabel(node Node) string {
+	label, exists := be.funcMap[be.symbols[node]]
+	if !exists {
+		panic(fmt.Sprintf("Undefined function in %T", node))
+	}
+	return label
+}
+
+// compileIndirectCall calls the function value held by a variable or
+// computed by the callee, CALLV pushes what it captured before jumping to it
+func (be *BytecodeEmitter) compileIndirectCall(call *CallExpr) int {
+	var fnReg int
+	var sig *FuncType
+	var ok bool
+	if call.Callee != nil {
+		fnReg = be.CompileExpr(call.Callee, false)
+		sig, ok = be.typeOf(call.Callee).(*FuncType)
+	} else {
+		fnReg = be.load(call)
+		sig, ok = be.varTypes[be.symbols[call]].(*FuncType)
+	}
+	if !ok || len(sig.Params) != len(call.Args.Args) {
+		// the Checker reports this, the callee would pop the wrong values
+		panic(fmt.Sprintf("Cannot call %s with %d arguments", call.Function.Name, len(call.Args.Args)))
+	}
+	argRegs := make([]int, len(call.Args.Args))
+	for i, arg := range call.Args.Args {
+		argRegs[i] = be.CompileExpr(arg.Value, false)
+		if sig.Params[i] == FloatType {
+			argRegs[i] = be.toFloat(arg.Value, argRegs[i])
+		}
+	}
+	if be.frame != nil {
+		be.Emit(SAVE, be.frame.start, 0)
+		be.frame.patches = append(be.frame.patches, len(be.Instructions)-1)
+	}
+	for _, reg := range argRegs {
+		be.Emit(PUSH, Register(reg))
+	}
+	be.Emit(CALLV, fnReg)
+	if be.frame != nil {
+		be.Emit(RESTORE, be.frame.start, 0)
+		be.frame.patches = append(be.frame.patches, len(be.Instructions)-1)
+	}
+	resultReg := be.allocTemp(be.register)
+	be.Emit(MOV, Register(RAX), resultReg)
+	return resultReg
+}
+
+// isPlainOperand reports whether an expression is a literal or a variable
+func isPlainOperand(expr Expr) bool {
+	switch expr.(type) {
+	case *NumLiteral, *FloatLiteral, *StringLiteral, *BoolLiteral, *Ident:
+		return true
+	default:
+		return false
+	}
+}
+
+// toFloat converts an int register to a float for mixed arithmetic
+func (be *BytecodeEmitter) toFloat(expr Expr, reg int) int {
+	if be.typeOf(expr) == FloatType {
+		return reg
+	}
+	tmp := be.allocTemp(be.register)
+	be.Emit(ITOF, reg, tmp)
+	return tmp
+}
+
+// compileLogical emits `and`/`or` with short-circuiting, the right hand side
+// is only evaluated when the left doesn't already decide the result
+func (be *BytecodeEmitter) compileLogical(e *BinaryExpr) int {
+	resultReg := be.allocTemp(be.register)
+	endLabel := be.NewLabel()
+	leftReg := be.CompileExpr(e.Left, true)
+	be.Emit(MOV, Register(leftReg), resultReg)
+	if e.Operator == And {
+		be.Emit(JNT, resultReg, endLabel)
+	} else {
+		rightLabel := be.NewLabel()
+		be.Emit(JNT, resultReg, rightLabel)
+		be.Emit(JMP, endLabel)
+		be.EmitLabel(rightLabel)
+	}
+	rightReg := be.CompileExpr(e.Right, true)
+	be.Emit(MOV, Register(rightReg), resultReg)
+	be.EmitLabel(endLabel)
+	return resultReg
+}
+
 func (be *BytecodeEmitter) CompileExpr(expr Expr, isConditional bool) int {
+	if reg, ok := be.evaluated[expr]; ok {
+		return reg
+	}
 	switch e := expr.(type) {
 	case nil:
 		return 0
@@ -325,38 +888,176 @@ func (be *BytecodeEmitter) CompileExpr(expr Expr, isConditional bool) int {
 		be.register++
 		be.Emit(MOV, &LitValue{e.Value}, reg)
 		return reg
+	case *FloatLiteral:
+		reg := be.register
+		be.register++
+		be.Emit(MOV, &LitValue{e.Value}, reg)
+		return reg
 	case *FuncArg:
 		return be.CompileExpr(e.Value, false)
 	case *Ident:
-		if reg, found := be.varRegisterMap[e.Name]; found {
-			return reg
+		if sym := be.symbols[e]; sym != nil && sym.Kind == FuncSymbol {
+			// a named function as a value is a closure without captures
+			dest := be.allocTemp(be.register)
+			be.Emit(CLOSURE, dest, be.funcLabel(e), sym.Name)
+			return dest
+		}
+		return be.load(e)
+	case *Lambda:
+		label := fmt.Sprintf("__lambda%%_%d", len(be.lambdaLabels))
+		be.lambdaLabels[e] = label
+		be.lambdas = append(be.lambdas, e)
+		// CLOSURE dest, label, name, the registers of the captured cells...
+		dest := be.allocTemp(be.register)
+		args := []interface{}{dest, label, ""}
+		for _, sym := range be.captures[e] {
+			args = append(args, be.symRegisters[sym])
 		}
-		slog.Debug("var map: ", slog.Any("map", be.varRegisterMap))
-		panic(fmt.Sprintf("Variable %s not found", e.Name))
+		be.Emit(CLOSURE, args...)
+		return dest
 	case *StringLiteral:
 		reg := be.allocTemp(be.register)
 		be.Emit(MOV, &LitValue{e.string}, reg)
 		return reg
+	case *InterpString:
+		// build the string up left to right, converting non string parts
+		resultReg := be.allocTemp(be.register)
+		be.Emit(MOV, &LitValue{""}, resultReg)
+		for _, part := range e.Parts {
+			partReg := be.CompileExpr(part, false)
+			if _, ok := part.(*StringLiteral); !ok {
+				strReg := be.allocTemp(be.register)
+				be.Emit(TOSTR, partReg, strReg)
+				partReg = strReg
+			}
+			be.Emit(ADD, resultReg, partReg, resultReg)
+		}
+		return resultReg
 	case *CallExpr:
-		fnLabel, exists := be.funcMap[e.Function.Name]
-		if !exists {
-			panic(fmt.Sprintf("Undefined function: %s", e.Function.Name))
+		if e.Builtin != nil {
+			return be.CompileExpr(e.Builtin, isConditional)
+		}
+		if sym := be.symbols[e]; e.Callee != nil || sym != nil && sym.Kind != FuncSymbol {
+			return be.compileIndirectCall(e)
+		}
+		fnLabel := be.funcLabel(e)
+		params := be.funcParams[be.symbols[e]]
+		if len(e.Args.Args) != len(params) {
+			// the Checker reports this, the callee would pop the wrong values
+			panic(fmt.Sprintf("%s takes %d arguments, got %d", e.Function.Name, len(params), len(e.Args.Args)))
+		}
+		argRegs := make([]int, len(e.Args.Args))
+		for i, arg := range e.Args.Args {
+			argRegs[i] = be.CompileExpr(arg.Value, false)
+			if i < len(params) && params[i].Type == FloatType {
+				argRegs[i] = be.toFloat(arg.Value, argRegs[i])
+			}
+		}
+		if be.frame != nil {
+			be.Emit(SAVE, be.frame.start, 0)
+			be.frame.patches = append(be.frame.patches, len(be.Instructions)-1)
 		}
-		for _, arg := range e.Args.Args {
-			argReg := be.CompileExpr(arg.Value, false)
-			be.Emit(PUSH, Register(argReg))
+		for _, reg := range argRegs {
+			be.Emit(PUSH, Register(reg))
 		}
 		be.Emit(FNCALL, fnLabel)
-		return RAX
+		if be.frame != nil {
+			be.Emit(RESTORE, be.frame.start, 0)
+			be.frame.patches = append(be.frame.patches, len(be.Instructions)-1)
+		}
+		// copy the result out of RAX before another call overwrites it
+		resultReg := be.allocTemp(be.register)
+		be.Emit(MOV, Register(RAX), resultReg)
+		return resultReg
 	case *InputIntCall:
 		reg := be.CompileExpr(e.Input, false)
 		tmp := be.allocTemp(be.register)
 		be.Emit(SYSCALL, INPUT, reg, tmp)
 		return tmp
-	case *ReturnExpr:
+	case *Array:
+		// the items go on the data stack, NEWARR pops them into a new array
+		elem := be.elemType(e)
+		itemRegs := make([]int, len(e.Items))
+		for i, item := range e.Items {
+			itemRegs[i] = be.CompileExpr(item, false)
+			if elem == FloatType {
+				itemRegs[i] = be.toFloat(item, itemRegs[i])
+			}
+		}
+		for _, reg := range itemRegs {
+			be.Emit(PUSH, Register(reg))
+		}
+		dest := be.allocTemp(be.register)
+		be.Emit(NEWARR, len(e.Items), dest)
+		return dest
+	case *IndexExpr:
+		arrReg := be.CompileExpr(e.Target, false)
+		indexReg := be.CompileExpr(e.Index, false)
+		dest := be.allocTemp(be.register)
+		be.Emit(INDEX, arrReg, indexReg, dest)
+		return dest
+	case *LenCall:
 		reg := be.CompileExpr(e.Value, false)
-		be.Emit(MOV, Register(reg), RAX)
-		be.Emit(RET)
+		dest := be.allocTemp(be.register)
+		be.Emit(LEN, reg, dest)
+		return dest
+	case *MapLit:
+		// the entries go on the data stack as key, value pairs, NEWMAP pops
+		// them and inserts them in the order they were written
+		value := be.valueType(e)
+		regs := make([]int, 0, 2*len(e.Keys))
+		for i := range e.Keys {
+			keyReg := be.CompileExpr(e.Keys[i], false)
+			valueReg := be.CompileExpr(e.Values[i], false)
+			if value == FloatType {
+				valueReg = be.toFloat(e.Values[i], valueReg)
+			}
+			regs = append(regs, keyReg, valueReg)
+		}
+		for _, reg := range regs {
+			be.Emit(PUSH, Register(reg))
+		}
+		dest := be.allocTemp(be.register)
+		be.Emit(NEWMAP, len(e.Keys), dest)
+		return dest
+	case *HasCall:
+		mapReg := be.CompileExpr(e.Map, false)
+		keyReg := be.CompileExpr(e.Key, false)
+		dest := be.allocTemp(be.register)
+		be.Emit(HAS, mapReg, keyReg, dest)
+		return dest
+	case *KeysCall:
+		mapReg := be.CompileExpr(e.Map, false)
+		dest := be.allocTemp(be.register)
+		be.Emit(KEYS, mapReg, dest)
+		return dest
+	case *StructLit:
+		// the fields are evaluated in the order they were written, but pushed
+		// in the order they were declared. NEWOBJ dest, name, fields...
+		st := be.structType(e)
+		valueRegs := make(map[string]int)
+		for _, field := range e.Fields {
+			valueRegs[field.Name] = be.CompileExpr(field.Value, false)
+			if _, typ := st.field(field.Name); typ == FloatType {
+				valueRegs[field.Name] = be.toFloat(field.Value, valueRegs[field.Name])
+			}
+		}
+		dest := be.allocTemp(be.register)
+		args := []interface{}{dest, st.Name}
+		for _, field := range st.Fields {
+			be.Emit(PUSH, Register(valueRegs[field.Name]))
+			args = append(args, field.Name)
+		}
+		be.Emit(NEWOBJ, args...)
+		return dest
+	case *FieldExpr:
+		objReg := be.CompileExpr(e.Target, false)
+		idx, _ := be.structType(e.Target).field(e.Field)
+		dest := be.allocTemp(be.register)
+		be.Emit(GETFIELD, objReg, idx, dest)
+		return dest
+	case *ReturnExpr:
+		be.emitReturn(e.Value)
 		return RAX
 	case *InputStrCall:
 		reg := be.CompileExpr(e.Input, false)
@@ -366,50 +1067,51 @@ func (be *BytecodeEmitter) CompileExpr(expr Expr, isConditional bool) int {
 	case *UnaryExpr:
 		operandReg := be.CompileExpr(e.Operand, false)
 		resultReg := be.allocTemp(be.register)
-		be.Emit(opcodeMap[e.Operator], operandReg, resultReg)
+		opcode := unaryOpcodeMap[e.Operator]
+		if e.Operator == Minus && be.typeOf(e.Operand) == FloatType {
+			opcode = FNEG
+		}
+		be.Emit(opcode, operandReg, resultReg)
 		return resultReg
 	case *BinaryExpr:
-		if e.Operator == Eq {
-			ident, ok := e.Left.(*Ident)
-			if !ok {
-				panic("Left-hand side of assignment must be an identifier")
-			}
-			valueReg := be.CompileExpr(e.Right, false)
-			reg := be.AllocateRegister(ident.Name)
-			be.Emit(MOV, Register(valueReg), reg)
-			return reg
+		if e.Operator == And || e.Operator == Or {
+			return be.compileLogical(e)
 		}
 		leftReg := be.CompileExpr(e.Left, isConditionalOp(e.Operator))
 		rightReg := be.CompileExpr(e.Right, isConditionalOp(e.Operator))
+		opcode := opcodeMap[e.Operator]
+		if fop, ok := floatOpcodeMap[e.Operator]; ok && (be.typeOf(e.Left) == FloatType || be.typeOf(e.Right) == FloatType) {
+			leftReg = be.toFloat(e.Left, leftReg)
+			rightReg = be.toFloat(e.Right, rightReg)
+			opcode = fop
+		}
 		if !isConditionalOp(e.Operator) { // Handle arithmetic or bitwise operators
 			resultReg := be.AllocateRegister(fmt.Sprintf("__temp%d", be.register))
-			be.Emit(opcodeMap[e.Operator], leftReg, rightReg, resultReg)
+			be.Emit(opcode, leftReg, rightReg, resultReg)
 			return resultReg
 		}
 		trueLabel := be.NewLabel()
 		endLabel := be.NewLabel()
 		switch e.Operator {
 		case EqEq, Neq, Gt, Gte, Lt, Lte:
-			be.Emit(opcodeMap[e.Operator], leftReg, rightReg, trueLabel)
+			be.Emit(opcode, leftReg, rightReg, trueLabel)
 		default:
 			panic(fmt.Sprintf("Unhandled operator %v in BinaryExpr", e.Operator))
 		}
 		resultReg := be.allocTemp(be.register)
-		be.Emit(MOV, &LitValue{0}, resultReg)
+		be.Emit(MOV, &LitValue{false}, resultReg)
 		be.Emit(JMP, endLabel)
 		be.EmitLabel(trueLabel)
-		be.Emit(MOV, &LitValue{Value: 1}, resultReg)
+		be.Emit(MOV, &LitValue{Value: true}, resultReg)
 		be.EmitLabel(endLabel)
 		return resultReg
 
 	case *BoolLiteral:
 		reg := be.allocTemp(be.register)
-		val := 0
-		if e.bool {
-			val = 1
-		}
-		be.Emit(MOV, val, reg)
-
+		be.Emit(MOV, &LitValue{e.bool}, reg)
+		return reg
+	case *IfExpr:
+		return be.compileIfExpr(e)
 	}
 	return 0
 }
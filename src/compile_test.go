@@ -0,0 +1,362 @@
+package src
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// result is what the front end made of a test program
+type result struct {
+	ast      *AST
+	errs     []error   // of the first stage that reported any, the later ones didn't run
+	warnings []Warning // from the Resolver
+	loader   *Loader   // to render the diagnostics
+}
+
+// first is the first diagnostic reported, nil if the program compiled
+func (r result) first() error {
+	if len(r.errs) == 0 {
+		return nil
+	}
+	return r.errs[0]
+}
+
+// frontend loads the program lexer holds and the modules it imports, then
+// resolves and type checks it, stopping at the first stage with errors
+func frontend(t *testing.T, lexer *Lexer) result {
+	t.Helper()
+	res := result{loader: NewLoader()}
+	ast, loadErrs := res.loader.Load(lexer)
+	if len(loadErrs) > 0 {
+		res.errs = loadErrs
+		return res
+	}
+	resolver := NewResolver(ast)
+	ast, nameErrs := resolver.Resolve()
+	res.warnings = resolver.Warnings()
+	for _, err := range nameErrs {
+		res.errs = append(res.errs, err)
+	}
+	if len(res.errs) > 0 {
+		return res
+	}
+	ast, typeErrs := NewChecker(ast).Check()
+	for _, err := range typeErrs {
+		res.errs = append(res.errs, err)
+	}
+	res.ast = ast
+	return res
+}
+
+// firstError is the first diagnostic any stage reports for src
+func firstError(t *testing.T, src string) error {
+	t.Helper()
+	return frontend(t, NewInputLexer(src)).first()
+}
+
+// check runs the front end over the program lexer holds, failing the test on any error
+func check(t *testing.T, lexer *Lexer) *AST {
+	t.Helper()
+	res := frontend(t, lexer)
+	for _, err := range res.errs {
+		t.Fatal(res.loader.Print(err))
+	}
+	return res.ast
+}
+
+// compile checks and emits bytecode for src, failing the test on any error
+func compile(t *testing.T, src string) *BytecodeEmitter {
+	t.Helper()
+	be := NewBytecodeEmitter()
+	be.Walk(check(t, NewInputLexer(src)))
+	return be
+}
+
+// run executes the bytecode and returns everything the program printed
+func run(t *testing.T, vm *GoVM) string {
+	t.Helper()
+	out, err := execute(t, vm)
+	if err != nil {
+		t.Errorf("runtime error: %v", err)
+	}
+	return out
+}
+
+// execute is run for programs that are expected to fail at runtime
+func execute(t *testing.T, vm *GoVM) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- string(out)
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	err = vm.Exec()
+	w.Close()
+	return <-done, err
+}
+
+func expectOutput(t *testing.T, got string, want ...string) {
+	t.Helper()
+	expected := ""
+	for _, line := range want {
+		expected += "PRINT: " + line + "\n"
+	}
+	if got != expected {
+		t.Errorf("unexpected output\ngot:\n%s\nwant:\n%s", got, expected)
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	be := compile(t, `
+let n = 2
+let f = 1.5
+let name = "ayc"
+print("{name}: n={n}, f={f}, sum={n + 1}, big={n > 1}")
+print("{"nested {n}"}!")
+`)
+	expectOutput(t, run(t, NewVM(be.Instructions)), "ayc: n=2, f=1.5, sum=3, big=true", "nested 2!")
+}
+
+func TestWhileLoop(t *testing.T) {
+	be := compile(t, `
+let i = 0
+let total = 0
+while i < 5 {
+	total = total + i
+	i = i + 1
+}
+print(total)
+print(i)
+`)
+	expectOutput(t, run(t, NewVM(be.Instructions)), "10", "5")
+}
+
+func TestWhileLoopNeverRuns(t *testing.T) {
+	be := compile(t, `
+let i = 10
+while (i < 5) {
+	print("unreachable")
+}
+print("done")
+`)
+	expectOutput(t, run(t, NewVM(be.Instructions)), "done")
+}
+
+func TestNestedWhileLoops(t *testing.T) {
+	be := compile(t, `
+let i = 0
+let count = 0
+while i < 3 {
+	let j = 0
+	while j < 4 {
+		count = count + 1
+		j = j + 1
+	}
+	i = i + 1
+}
+print(count)
+`)
+	expectOutput(t, run(t, NewVM(be.Instructions)), "12")
+}
+
+func TestWhileLoopDeepIteration(t *testing.T) {
+	be := compile(t, `
+let i = 0
+while i < 200000 {
+	i = i + 1
+}
+print(i)
+`)
+	for _, insn := range be.Instructions {
+		if insn.Opcode == FNCALL {
+			t.Fatalf("while loop should compile to jumps, found %v", insn)
+		}
+	}
+	vm := NewVM(be.Instructions)
+	expectOutput(t, run(t, vm), "200000")
+	if vm.callStack.Len() != 0 || vm.stack.Len() != 0 {
+		t.Errorf("loop grew the stacks: call stack %d, data stack %d", vm.callStack.Len(), vm.stack.Len())
+	}
+}
+
+func TestWhileLoopOptimized(t *testing.T) {
+	ast := NewAnalyzer(check(t, NewInputLexer(`
+let i = 0
+while i < 3 {
+	print(i)
+	i = i + 1
+}
+`))).AnalyzeAndEval()
+	be := NewBytecodeEmitter()
+	be.Walk(ast)
+	expectOutput(t, run(t, NewVM(be.Instructions)), "0", "1", "2")
+}
+
+func TestIndexOutOfBounds(t *testing.T) {
+	be := compile(t, `
+let a = [1, 2, 3]
+print(a[2])
+print(a[3])
+print("unreachable")
+`)
+	out, err := execute(t, NewVM(be.Instructions))
+	expectOutput(t, out, "3")
+	if err == nil || err.Error() != "Index 3 out of bounds for an array of length 3" {
+		t.Errorf("expected an out of bounds error, got %v", err)
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	tests := []struct {
+		op   string
+		want string // of 7 op 2
+	}{
+		{"/", "3"},
+		{"%", "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.op, func(t *testing.T) {
+			be := compile(t, fmt.Sprintf(`
+let zero = 0
+print(7 %s 2)
+print(10 %s zero)
+print("unreachable")
+`, tt.op, tt.op))
+			out, err := execute(t, NewVM(be.Instructions))
+			expectOutput(t, out, tt.want)
+			if err == nil || err.Error() != "Division by zero" {
+				t.Errorf("expected a division by zero error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestBuiltinNames(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"variable", "let len = 2\nprint(len)", []string{"2"}},
+		{"parameter", "def count(len: int) -> int {\n\treturn len + 1\n}\nprint(count(len([1, 2])))", []string{"3"}},
+		{"function", "def len(s: str) -> int {\n\treturn 42\n}\nprint(len(\"ab\"))", []string{"42"}},
+		{"map builtins", "let has = {\"a\": 1, \"b\": 2}\nlet map: map[str]int = has\ndelete(map, \"a\")\nprint(len(keys(has)))", []string{"1"}},
+		{"shadowed in a block", "if true {\n\tlet len = 5\n\tprint(len)\n}\nprint(len(\"ab\"))", []string{"5", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			be := compile(t, tt.src)
+			expectOutput(t, run(t, NewVM(be.Instructions)), tt.want...)
+		})
+	}
+}
+
+func TestCompoundAssignEvaluatesTargetOnce(t *testing.T) {
+	be := compile(t, `
+struct P { x: int }
+let calls = 0
+let xs = [10, 20, 30]
+let p = P { x: 1 }
+def next() -> int {
+	calls += 1
+	return calls - 1
+}
+def get() -> P {
+	calls += 1
+	return p
+}
+xs[next()] += 1
+xs[next()]++
+print("{xs[0]} {xs[1]} {xs[2]} {calls}")
+get().x *= 5
+get().x--
+print("{p.x} {calls}")
+`)
+	expectOutput(t, run(t, NewVM(be.Instructions)), "11 21 30 2", "4 4")
+}
+
+func TestMissingMapKey(t *testing.T) {
+	be := compile(t, `
+let m = {"a": 1}
+print(m["a"])
+print(m["b"])
+`)
+	out, err := execute(t, NewVM(be.Instructions))
+	expectOutput(t, out, "1")
+	if err == nil || err.Error() != `Key "b" is not in the map` {
+		t.Errorf("expected a missing key error, got %v", err)
+	}
+}
+
+func TestArraysArePassedByReference(t *testing.T) {
+	be := compile(t, `
+def fill(xs: [int], n: int) -> [int] {
+	for (let i = 0; i < len(xs); i++) {
+		xs[i] = n
+	}
+	return xs
+}
+let a = [0, 0]
+let b = fill(a, 7)
+b[0] = 1
+print(a)
+`)
+	expectOutput(t, run(t, NewVM(be.Instructions)), "[1, 7]")
+}
+
+var update = flag.Bool("update", false, "rewrite the golden .out files in testdata")
+
+// TestGolden runs every program in testdata, with and without the analyzer,
+// and compares what it prints against the matching .out file
+func TestGolden(t *testing.T) {
+	programs, err := filepath.Glob("testdata/*.ayc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, program := range programs {
+		name := strings.TrimSuffix(filepath.Base(program), ".ayc")
+		golden := strings.TrimSuffix(program, ".ayc") + ".out"
+		for _, optimize := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/optimize=%v", name, optimize), func(t *testing.T) {
+				lexer, err := NewLexer(program)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ast := check(t, lexer)
+				if optimize {
+					ast = NewAnalyzer(ast).AnalyzeAndEval()
+				}
+				be := NewBytecodeEmitter()
+				be.Walk(ast)
+				got := run(t, NewVM(be.Instructions))
+				if *update && !optimize {
+					if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != string(want) {
+					t.Errorf("output differs from %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+				}
+			})
+		}
+	}
+}
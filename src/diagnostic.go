@@ -0,0 +1,108 @@
+package src
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LexError is a diagnostic produced while tokenizing, the lexer keeps going
+// after recording one so a single run reports every bad character.
+type LexError struct {
+	Span Span
+	Char rune
+	Msg  string
+}
+
+func (e LexError) Error() string {
+	return e.Msg
+}
+
+// ParseError is a syntax error, the parser records it and resynchronizes at
+// the next statement so that every error in a file is reported at once.
+type ParseError struct {
+	Span Span
+	Msg  string
+}
+
+func (e ParseError) Error() string {
+	return e.Msg
+}
+
+// TypeError is reported by the Checker, like parse errors they are collected
+// so that every mismatch in a file is reported at once.
+type TypeError struct {
+	Span Span
+	Msg  string
+}
+
+func (e TypeError) Error() string {
+	return e.Msg
+}
+
+// NameError is reported by the Resolver for a name that nothing declares
+type NameError struct {
+	Span Span
+	Msg  string
+}
+
+func (e NameError) Error() string {
+	return e.Msg
+}
+
+// ImportError is reported by the Loader for an import it can't follow, e.g.
+// a missing file or a cycle
+type ImportError struct {
+	Span Span
+	Msg  string
+}
+
+func (e ImportError) Error() string {
+	return e.Msg
+}
+
+// RuntimeError stops the VM, e.g. an index out of bounds. Bytecode doesn't
+// keep spans, so it only has a message
+type RuntimeError struct {
+	Msg string
+}
+
+func (e RuntimeError) Error() string {
+	return e.Msg
+}
+
+// Warning is a problem that doesn't stop compilation, e.g. an unused variable
+type Warning struct {
+	Span Span
+	Msg  string
+}
+
+func (w Warning) Error() string {
+	return w.Msg
+}
+
+func renderDiagnostic(input string, span Span, msg string) string {
+	relevantCode := ""
+	lines := strings.Split(input, "\n")
+	if span.line > 0 && span.line <= len(lines) {
+		relevantCode = lines[span.line-1]
+	}
+	// keep tabs in the padding so the markers line up with the code above
+	padding := ""
+	for i, char := range []rune(relevantCode) {
+		if i >= span.col-1 {
+			break
+		}
+		if char == '\t' {
+			padding += "\t"
+		} else {
+			padding += " "
+		}
+	}
+	width := 1
+	if span.endLine == span.line && span.endCol > span.col {
+		width = span.endCol - span.col
+	}
+	return fmt.Sprintf(`%v: %s
+		%s
+		%s%s%s%s`, span, msg, relevantCode, padding, Red, strings.Repeat("^", width), Reset)
+}
@@ -0,0 +1,78 @@
+package src
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDiagnosticSpans(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		line    int
+		col     int
+		msg     string
+		padding string // before the carets, tabs are kept so they line up with the code
+		width   int    // of the carets
+	}{
+		{"lex error", "let x = 1 $", 1, 11, "Illegal character '$'", strings.Repeat(" ", 10), 1},
+		{"lex error after a multi-byte letter", "let é = 1 $", 1, 11, "Illegal character '$'", strings.Repeat(" ", 10), 1},
+		{"lex error after a tab", "\tlet x = #", 1, 10, "Illegal character '#'", "\t" + strings.Repeat(" ", 8), 1},
+		{"lex error spanning a token", "let x = 0b102", 1, 9, "Invalid digit in base 2 literal 0b102", strings.Repeat(" ", 8), 5},
+		{"parse error", "let = 1", 1, 5, "Expected Identifier, got Eq", strings.Repeat(" ", 4), 1},
+		{"parse error after a multi-byte string", `print("日本" +)`, 1, 13, "Unexpected token RParen", strings.Repeat(" ", 12), 1},
+		{"parse error on a later line", "let x = 1\nprint(x)\nprint(x +)", 3, 10, "Unexpected token RParen", strings.Repeat(" ", 9), 1},
+		{"name error", "let naïve = 1\nprint(naïf)", 2, 7, "Undeclared variable naïf", strings.Repeat(" ", 6), 4},
+		{"name error after a tab", "if true {\n\tprint(y)\n}", 2, 8, "Undeclared variable y", "\t" + strings.Repeat(" ", 6), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := frontend(t, NewInputLexer(tt.src))
+			err := res.first()
+			if err == nil {
+				t.Fatalf("expected %q", tt.msg)
+			}
+			lines := strings.Split(res.loader.Print(err), "\n")
+			if len(lines) != 3 {
+				t.Fatalf("expected a header, the code and the markers, got %q", lines)
+			}
+			if want := fmt.Sprintf("<input>:%d:%d: %s", tt.line, tt.col, tt.msg); lines[0] != want {
+				t.Errorf("got %q, want %q", lines[0], want)
+			}
+			if want := strings.Split(tt.src, "\n")[tt.line-1]; strings.TrimPrefix(lines[1], "\t\t") != want {
+				t.Errorf("got code %q, want %q", lines[1], want)
+			}
+			want := tt.padding + Red + strings.Repeat("^", tt.width) + Reset
+			if markers := strings.TrimPrefix(lines[2], "\t\t"); markers != want {
+				t.Errorf("got markers %q, want %q", markers, want)
+			}
+		})
+	}
+}
+
+func TestTokenSpans(t *testing.T) {
+	tokens, _ := lex(t, "let π = 3.14\n\tprint(\"héllo\")")
+	want := []struct {
+		line, col, endLine, endCol int
+	}{
+		{1, 1, 1, 4},   // let
+		{1, 5, 1, 6},   // π is one column
+		{1, 7, 1, 8},   // =
+		{1, 9, 1, 13},  // 3.14
+		{2, 2, 2, 7},   // print, after the tab
+		{2, 7, 2, 8},   // (
+		{2, 8, 2, 15},  // "héllo"
+		{2, 15, 2, 16}, // )
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("expected %d tokens, got %d: %v", len(want), len(tokens), tokens)
+	}
+	for i, tk := range tokens {
+		s := tk.span
+		w := want[i]
+		if s.File() != "<input>" || s.Line() != w.line || s.Col() != w.col || s.EndLine() != w.endLine || s.EndCol() != w.endCol {
+			t.Errorf("%q: got %d:%d-%d:%d, want %d:%d-%d:%d", tk.val, s.Line(), s.Col(), s.EndLine(), s.EndCol(), w.line, w.col, w.endLine, w.endCol)
+		}
+	}
+}
@@ -1,57 +1,69 @@
 package src
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"log/slog"
 	"os"
 	"slices"
+	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
-	src         string
-	pos         int
-	current     rune
-	currentLine int
-	tokens      []Token
+	file    string
+	src     string
+	pos     int // byte offset of current
+	width   int // byte width of current
+	current rune
+	line    int
+	col     int
+	tokens  []Token
+	errors  []LexError
 }
 
 func NewInputLexer(input string) *Lexer {
-	return &Lexer{
-		src:         input,
-		pos:         0,
-		currentLine: 1,
-		current:     rune(input[0]),
-		tokens:      []Token{},
-	}
+	return newLexer("<input>", input)
 }
 
-func NewLexer(filepath string) *Lexer {
+func NewLexer(filepath string) (*Lexer, error) {
 	src, err := os.ReadFile(filepath)
 	if err != nil {
-		log.Fatal("Error reading file: ", err)
+		return nil, err
 	}
-	return &Lexer{
-		src:         string(src),
-		pos:         0,
-		currentLine: 1,
-		current:     rune(src[0]),
-		tokens:      []Token{},
+	return newLexer(filepath, string(src)), nil
+}
+
+func newLexer(file, input string) *Lexer {
+	lxr := &Lexer{
+		file:   file,
+		src:    input,
+		pos:    0,
+		line:   1,
+		col:    1,
+		tokens: []Token{},
 	}
+	lxr.decode()
+	return lxr
 }
 
-func (lxr *Lexer) Tokenize() *Parser {
-	for lxr.pos <= len(lxr.src) || lxr.current != '\x00' {
-		token := lxr.readToken()
-		if token.kind == EOF {
+// Tokenize lexes the whole input and returns a parser over the tokens,
+// along with every LexError found on the way.
+func (lxr *Lexer) Tokenize() (*Parser, []LexError) {
+	for {
+		lxr.skipWhitespace()
+		if lxr.pos >= len(lxr.src) {
 			break
 		}
-		lxr.tokens = append(lxr.tokens, token)
-		lxr.next()
+		if token, ok := lxr.readToken(); ok {
+			lxr.tokens = append(lxr.tokens, token)
+		}
 	}
+	lxr.tokens = append(lxr.tokens, newToken(EOF, "", lxr.spanFrom(lxr.mark())))
 	parser := NewParser(lxr)
-	return parser
+	return parser, lxr.errors
 }
 
 var keywords = map[string]tokenKind{
@@ -70,108 +82,464 @@ var keywords = map[string]tokenKind{
 	"not":       Not,
 	"def":       Defn,
 	"int":       Int,
+	"float":     Float,
 	"str":       String,
+	"bool":      Bool,
 	"void":      Void,
 	"for":       For,
 	"while":     While,
+	"struct":    Struct,
+	"fn":        Fn,
+	"import":    Import,
+	"pub":       Pub,
 }
 
 func (lxr *Lexer) skipComment() {
-	for lxr.current != '\n' {
+	for lxr.current != '\n' && lxr.pos < len(lxr.src) {
 		lxr.next()
 	}
 	lxr.next()
 }
 
-func (lxr *Lexer) skipWhitespace() {
-	if lxr.current == '\n' {
-		lxr.currentLine++
+// readLineComment skips a `//` comment, `///` doc comments are kept as
+// DocComment tokens so the parser can attach them to the next declaration
+func (lxr *Lexer) readLineComment(start Span) (Token, bool) {
+	lxr.next()
+	lxr.next()
+	if lxr.current != '/' || lxr.peek() == '/' {
+		lxr.skipComment()
+		return Token{}, false
+	}
+	lxr.next()
+	if lxr.current == ' ' {
+		lxr.next()
+	}
+	var doc strings.Builder
+	for lxr.current != '\n' && lxr.pos < len(lxr.src) {
+		doc.WriteRune(lxr.current)
+		lxr.next()
 	}
+	return newToken(DocComment, strings.TrimRight(doc.String(), " \t\r"), lxr.spanFrom(start)), true
+}
+
+// skipBlockComment skips a /* ... */ comment, they can be nested
+func (lxr *Lexer) skipBlockComment(start Span) {
+	depth := 0
+	for lxr.pos < len(lxr.src) {
+		if lxr.current == '/' && lxr.peek() == '*' {
+			depth++
+			lxr.next()
+		} else if lxr.current == '*' && lxr.peek() == '/' {
+			depth--
+			lxr.next()
+			if depth == 0 {
+				lxr.next()
+				return
+			}
+		}
+		lxr.next()
+	}
+	lxr.lexError(lxr.spanFrom(start), '/', "Unterminated block comment")
+}
+
+func (lxr *Lexer) skipWhitespace() {
 	for unicode.IsSpace(lxr.current) {
 		lxr.next()
 	}
 }
 
 func (lxr *Lexer) peek() rune {
-	if lxr.pos+1 >= len(lxr.src) {
+	if lxr.pos+lxr.width >= len(lxr.src) {
 		return '\x00'
 	} else {
-		return rune(lxr.src[lxr.pos+1])
+		char, _ := utf8.DecodeRuneInString(lxr.src[lxr.pos+lxr.width:])
+		return char
 	}
 }
 
+// next advances past the current character, keeping line and column in sync.
+// Columns count runes, not bytes.
 func (lxr *Lexer) next() {
-	lxr.pos++
+	if lxr.current == '\n' {
+		lxr.line++
+		lxr.col = 1
+	} else {
+		lxr.col++
+	}
+	lxr.pos += lxr.width
+	lxr.decode()
+}
+
+// decode reads the rune starting at pos into current
+func (lxr *Lexer) decode() {
 	if lxr.pos >= len(lxr.src) {
 		lxr.current = '\x00'
+		lxr.width = 0
 		return
 	}
-	lxr.current = rune(lxr.src[lxr.pos])
+	lxr.current, lxr.width = utf8.DecodeRuneInString(lxr.src[lxr.pos:])
+}
+
+// mark returns an empty span starting at the current character
+func (lxr *Lexer) mark() Span {
+	return Span{file: lxr.file, line: lxr.line, col: lxr.col, endLine: lxr.line, endCol: lxr.col}
 }
 
-func (lxr *Lexer) readToken() Token {
-	lxr.skipWhitespace()
+// spanFrom extends start up to (but not including) the current character
+func (lxr *Lexer) spanFrom(start Span) Span {
+	start.endLine = lxr.line
+	start.endCol = lxr.col
+	return start
+}
+
+// readToken consumes the next token, returning false if nothing was
+// produced (a comment, or an illegal character that was recorded as an error)
+func (lxr *Lexer) readToken() (Token, bool) {
+	start := lxr.mark()
 	kind := fromChar(lxr.current)
 	switch kind {
 	case Literal:
-		return lxr.readNumber()
+		return lxr.readNumber(start), true
 	case Identifier, Underscore:
-		return lxr.readIdent()
+		return lxr.readIdent(start), true
 	case Quote:
-		return lxr.readStringLit()
+		return lxr.readStringLit(start), true
+	case Backtick:
+		return lxr.readRawStringLit(start), true
 	default:
 		// if we get an EOF token, but not at the end of the file, its an error
 		if kind == EOF && lxr.pos < len(lxr.src) {
-			log.Fatalf("illegal token on line: %v", lxr.currentLine)
+			if lxr.current == utf8.RuneError && lxr.width == 1 {
+				lxr.invalidEncoding(start)
+				return Token{}, false
+			}
+			lxr.illegalChar(start)
+			return Token{}, false
 		}
-		if kind == Div && fromChar(lxr.peek()) == Div {
+		if kind == Div && lxr.peek() == '/' {
 			slog.Debug("found comment")
-			lxr.skipComment()
+			return lxr.readLineComment(start)
+		}
+		if kind == Div && lxr.peek() == '*' {
+			lxr.skipBlockComment(start)
+			return Token{}, false
 		}
 		// if it's not any of the above, it's a single char token
-		return lxr.readOp(kind)
+		return lxr.readOp(kind, start), true
 	}
 }
 
-func (lxr *Lexer) readOp(cur tokenKind) Token {
-	doubleOps := []tokenKind{Eq, Lt, Gt, BitAnd, BitOr, Bang, Minus}
+func (lxr *Lexer) illegalChar(start Span) {
+	char := lxr.current
+	lxr.next()
+	lxr.lexError(lxr.spanFrom(start), char, fmt.Sprintf("Illegal character %q", char))
+}
+
+func (lxr *Lexer) invalidEncoding(start Span) {
+	char := lxr.src[lxr.pos]
+	lxr.next()
+	lxr.lexError(lxr.spanFrom(start), utf8.RuneError, fmt.Sprintf("Invalid UTF-8 byte 0x%02x", char))
+}
+
+func (lxr *Lexer) readOp(cur tokenKind, start Span) Token {
+	doubleOps := []tokenKind{Eq, Lt, Gt, BitAnd, BitOr, Bang, Minus, Plus}
 	curChar := string(lxr.current)
-	if slices.Contains(doubleOps, cur) && slices.Contains(doubleOps, fromChar(lxr.peek())) {
-		lxr.next()
-		kind := doubleOp(cur, fromChar(lxr.current))
-		curChar += string(lxr.current)
-		return newToken(kind, fmt.Sprintf("%s ", curChar), lxr.currentLine, lxr.pos-2)
+	lxr.next()
+	if slices.Contains(doubleOps, cur) {
+		if kind := doubleOp(cur, fromChar(lxr.current)); kind != EOF {
+			cur = kind
+			curChar += string(lxr.current)
+			lxr.next()
+		}
 	}
-	return newToken(cur, fmt.Sprintf("%s ", curChar), lxr.currentLine, lxr.pos-1)
+	// a trailing '=' turns e.g. `+` into `+=` and `<<` into `<<=`
+	if fromChar(lxr.current) == Eq {
+		if kind := compoundOp(cur); kind != EOF {
+			cur = kind
+			curChar += string(lxr.current)
+			lxr.next()
+		}
+	}
+	return newToken(cur, fmt.Sprintf("%s ", curChar), lxr.spanFrom(start))
 }
 
-func (lxr *Lexer) readNumber() Token {
+// readNumber reads an int or float literal. Ints may have a 0x, 0b or 0o prefix,
+// and digits can be grouped with underscores, e.g. 1_000_000 or 0xff_ff
+func (lxr *Lexer) readNumber(start Span) Token {
 	numLit := "" // new empty string to store the number
-	for unicode.IsDigit(lxr.current) {
-		// while the current char is a number, store it in the string
+	isFloat := false
+	prefixed := lxr.current == '0' && strings.ContainsRune("xXbBoO", lxr.peek())
+	if prefixed {
+		numLit += string(lxr.current)
+		lxr.next()
 		numLit += string(lxr.current)
 		lxr.next()
 	}
-	lxr.tokens = append(lxr.tokens, newToken(Literal, numLit, lxr.currentLine, lxr.pos-len(numLit)))
-	return lxr.readToken()
+	for {
+		if !prefixed && (lxr.current == 'e' || lxr.current == 'E') {
+			// exponent, e.g. 1.5e-3
+			isFloat = true
+			numLit += string(lxr.current)
+			lxr.next()
+			if lxr.current == '+' || lxr.current == '-' {
+				numLit += string(lxr.current)
+				lxr.next()
+			}
+		} else if !prefixed && !isFloat && lxr.current == '.' && isDigit(lxr.peek()) {
+			isFloat = true
+			numLit += string(lxr.current)
+			lxr.next()
+		} else if isDigit(lxr.current) || unicode.IsLetter(lxr.current) || lxr.current == '_' {
+			// letters are consumed too, so 0b102 or 12abc are reported as one bad literal
+			numLit += string(lxr.current)
+			lxr.next()
+		} else {
+			break
+		}
+	}
+	span := lxr.spanFrom(start)
+	if isFloat {
+		if _, err := parseFloatLiteral(numLit); err != nil {
+			lxr.lexError(span, rune(numLit[0]), err.Error())
+		}
+		return newToken(FloatLit, numLit, span)
+	}
+	if _, err := parseIntLiteral(numLit); err != nil {
+		lxr.lexError(span, rune(numLit[0]), err.Error())
+	}
+	return newToken(Literal, numLit, span)
 }
 
-func (lxr *Lexer) readStringLit() Token {
+// parseIntLiteral converts the text of an int literal token to its value
+func parseIntLiteral(text string) (int, error) {
+	base := 10
+	digits := text
+	if len(text) >= 2 && text[0] == '0' {
+		switch text[1] {
+		case 'x', 'X':
+			base = 16
+		case 'b', 'B':
+			base = 2
+		case 'o', 'O':
+			base = 8
+		}
+		if base != 10 {
+			digits = text[2:]
+		}
+	}
+	if digits == "" {
+		return 0, fmt.Errorf("Missing digits in literal %s", text)
+	}
+	if !validSeparators(digits) {
+		return 0, fmt.Errorf("Invalid digit separator in literal %s", text)
+	}
+	val, err := strconv.ParseInt(strings.ReplaceAll(digits, "_", ""), base, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, fmt.Errorf("Integer literal %s overflows int", text)
+		}
+		return 0, fmt.Errorf("Invalid digit in base %d literal %s", base, text)
+	}
+	return int(val), nil
+}
+
+// parseFloatLiteral converts the text of a float literal token to its value
+func parseFloatLiteral(text string) (float64, error) {
+	if !validSeparators(text) {
+		return 0, fmt.Errorf("Invalid digit separator in literal %s", text)
+	}
+	val, err := strconv.ParseFloat(strings.ReplaceAll(text, "_", ""), 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, fmt.Errorf("Float literal %s overflows float", text)
+		}
+		return 0, fmt.Errorf("Invalid float literal %s", text)
+	}
+	return val, nil
+}
+
+// validSeparators checks that every underscore sits between two digits
+func validSeparators(digits string) bool {
+	isDigitAt := func(i int) bool {
+		if i < 0 || i >= len(digits) {
+			return false
+		}
+		// letters count as digits here, hex digits are letters
+		return isDigit(rune(digits[i])) || unicode.IsLetter(rune(digits[i]))
+	}
+	for i := 0; i < len(digits); i++ {
+		if digits[i] == '_' && (!isDigitAt(i-1) || !isDigitAt(i+1)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (lxr *Lexer) lexError(span Span, char rune, msg string) {
+	lxr.errors = append(lxr.errors, LexError{Span: span, Char: char, Msg: msg})
+}
+
+// readStringLit reads a double quoted string on a single line, processing escapes.
+// A string containing {expr} is lexed into parts: an InterpStart token, String
+// parts alternating with `LBrace expr RBrace`, then the returned InterpEnd.
+func (lxr *Lexer) readStringLit(start Span) Token {
 	lxr.next()
-	strLit := ""
-	for lxr.current != '"' {
-		strLit += string(lxr.current)
+	var strLit strings.Builder
+	partStart := lxr.mark()
+	interpStart := -1
+	terminated := false
+	for {
+		if lxr.current == '"' {
+			terminated = true
+			break
+		}
+		if lxr.current == '\n' || lxr.pos >= len(lxr.src) {
+			lxr.lexError(lxr.spanFrom(start), '"', "Unterminated string literal")
+			break
+		}
+		if lxr.current == '\\' {
+			strLit.WriteRune(lxr.readEscape())
+			continue
+		}
+		if lxr.current == '{' {
+			if interpStart < 0 {
+				interpStart = len(lxr.tokens)
+				lxr.tokens = append(lxr.tokens, newToken(InterpStart, "", start))
+			}
+			if strLit.Len() > 0 {
+				lxr.tokens = append(lxr.tokens, newToken(String, strLit.String(), lxr.spanFrom(partStart)))
+				strLit.Reset()
+			}
+			lxr.readInterpolation()
+			partStart = lxr.mark()
+			continue
+		}
+		strLit.WriteRune(lxr.current)
 		lxr.next()
 	}
+	if interpStart < 0 {
+		if terminated {
+			lxr.next()
+		}
+		return newToken(String, strLit.String(), lxr.spanFrom(start))
+	}
+	if strLit.Len() > 0 {
+		lxr.tokens = append(lxr.tokens, newToken(String, strLit.String(), lxr.spanFrom(partStart)))
+	}
+	if terminated {
+		lxr.next()
+	}
+	lxr.tokens[interpStart].span = lxr.spanFrom(start)
+	return newToken(InterpEnd, "", lxr.spanFrom(start))
+}
+
+// readInterpolation lexes the {expr} part of a string as regular tokens
+func (lxr *Lexer) readInterpolation() {
+	open := lxr.mark()
+	lxr.next() // {
+	lxr.tokens = append(lxr.tokens, newToken(LBrace, "{ ", lxr.spanFrom(open)))
+	depth := 0
+	for {
+		for lxr.current == ' ' || lxr.current == '\t' {
+			lxr.next()
+		}
+		if lxr.current == '\n' || lxr.pos >= len(lxr.src) {
+			lxr.lexError(lxr.spanFrom(open), '{', "Unterminated interpolation, expected '}'")
+			return
+		}
+		if lxr.current == '}' && depth == 0 {
+			break
+		}
+		token, ok := lxr.readToken()
+		if !ok {
+			continue
+		}
+		switch token.kind {
+		case LBrace:
+			depth++
+		case RBrace:
+			depth--
+		}
+		lxr.tokens = append(lxr.tokens, token)
+	}
+	closing := lxr.mark()
+	lxr.next() // }
+	lxr.tokens = append(lxr.tokens, newToken(RBrace, "} ", lxr.spanFrom(closing)))
+}
+
+// readRawStringLit reads a backtick string verbatim, it may span multiple lines
+func (lxr *Lexer) readRawStringLit(start Span) Token {
 	lxr.next()
-	lxr.tokens = append(lxr.tokens, newToken(String, strLit, lxr.currentLine, lxr.pos-len(strLit)))
-	return lxr.readToken()
+	var strLit strings.Builder
+	for lxr.current != '`' {
+		if lxr.pos >= len(lxr.src) {
+			lxr.lexError(lxr.spanFrom(start), '`', "Unterminated raw string literal")
+			return newToken(String, strLit.String(), lxr.spanFrom(start))
+		}
+		strLit.WriteRune(lxr.current)
+		lxr.next()
+	}
+	lxr.next()
+	return newToken(String, strLit.String(), lxr.spanFrom(start))
+}
+
+var escapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  '\x00',
+	'"':  '"',
+	'\'': '\'',
+	'\\': '\\',
+	'{':  '{',
+	'}':  '}',
+}
+
+// readEscape consumes a backslash escape sequence and returns the rune it stands for
+func (lxr *Lexer) readEscape() rune {
+	start := lxr.mark()
+	lxr.next() // \
+	char := lxr.current
+	if esc, ok := escapes[char]; ok {
+		lxr.next()
+		return esc
+	}
+	if char != 'u' {
+		if char != '\n' && lxr.pos < len(lxr.src) {
+			lxr.next()
+		}
+		lxr.lexError(lxr.spanFrom(start), char, fmt.Sprintf("Unknown escape sequence \\%c", char))
+		return char
+	}
+	// \u{XXXX}
+	lxr.next()
+	if lxr.current != '{' {
+		lxr.lexError(lxr.spanFrom(start), lxr.current, "Expected '{' after \\u")
+		return utf8.RuneError
+	}
+	lxr.next()
+	hex := ""
+	for lxr.current != '}' && lxr.current != '"' && lxr.current != '\n' && lxr.pos < len(lxr.src) {
+		hex += string(lxr.current)
+		lxr.next()
+	}
+	if lxr.current != '}' {
+		lxr.lexError(lxr.spanFrom(start), lxr.current, "Unterminated unicode escape, expected '}'")
+		return utf8.RuneError
+	}
+	lxr.next()
+	code, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) > 6 || !utf8.ValidRune(rune(code)) {
+		lxr.lexError(lxr.spanFrom(start), 'u', fmt.Sprintf("Invalid unicode escape \\u{%s}", hex))
+		return utf8.RuneError
+	}
+	return rune(code)
 }
 
-func (lxr *Lexer) readIdent() Token {
+func (lxr *Lexer) readIdent(start Span) Token {
 	ident := "" // new empty string to store the identifier
 	// at this point we don't know if it's a keyword or a variable
-	for unicode.IsLetter(lxr.current) || unicode.IsDigit(lxr.current) || lxr.current == '_' {
+	for unicode.IsLetter(lxr.current) || unicode.IsDigit(lxr.current) || unicode.IsMark(lxr.current) || lxr.current == '_' {
 		ident += string(lxr.current)
 		lxr.next()
 	}
@@ -179,9 +547,7 @@ func (lxr *Lexer) readIdent() Token {
 	kind, ok := keywords[ident]
 	if !ok {
 		// if not, it's a variable so we return Identifier
-		lxr.tokens = append(lxr.tokens, newToken(Identifier, ident, lxr.currentLine, lxr.pos-len(ident)))
-		return lxr.readToken()
+		return newToken(Identifier, ident, lxr.spanFrom(start))
 	}
-	lxr.tokens = append(lxr.tokens, newToken(kind, ident, lxr.currentLine, lxr.pos-len(ident)))
-	return lxr.readToken()
+	return newToken(kind, ident, lxr.spanFrom(start))
 }
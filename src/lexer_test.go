@@ -0,0 +1,264 @@
+package src
+
+import (
+	"testing"
+)
+
+// lex tokenizes src, returning its tokens without the closing EOF
+func lex(t *testing.T, src string) ([]Token, []LexError) {
+	t.Helper()
+	parser, errs := NewInputLexer(src).Tokenize()
+	return parser.tokens[:len(parser.tokens)-1], errs
+}
+
+func TestLexerReportsEveryError(t *testing.T) {
+	tokens, errs := lex(t, "let x = 1 $ 2 @")
+	want := []struct {
+		col int
+		msg string
+	}{
+		{11, "Illegal character '$'"},
+		{15, "Illegal character '@'"},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("expected %d errors, got %d: %v", len(want), len(errs), errs)
+	}
+	for i, err := range errs {
+		if err.Msg != want[i].msg || err.Span.Col() != want[i].col {
+			t.Errorf("got %v: %q, want 1:%d: %q", err.Span, err.Msg, want[i].col, want[i].msg)
+		}
+	}
+	// the bad characters are dropped, everything around them is still lexed
+	kinds := []tokenKind{Let, Identifier, Eq, Literal, Literal}
+	if len(tokens) != len(kinds) {
+		t.Fatalf("expected %d tokens, got %d: %v", len(kinds), len(tokens), tokens)
+	}
+	for i, tk := range tokens {
+		if tk.kind != kinds[i] {
+			t.Errorf("token %d: got %v, want %v", i, tk.kind.ToString(), kinds[i].ToString())
+		}
+	}
+}
+
+func TestLexerDecodesUTF8(t *testing.T) {
+	tests := []struct {
+		src  string
+		kind tokenKind
+		val  string
+	}{
+		{"café", Identifier, "café"},
+		{"日本語", Identifier, "日本語"},
+		{"e\u0301tat", Identifier, "e\u0301tat"}, // a combining accent is part of the name
+		{`"héllo 🌍"`, String, "héllo 🌍"},
+	}
+	for _, tt := range tests {
+		tokens, errs := lex(t, tt.src)
+		if len(errs) != 0 {
+			t.Errorf("%q: unexpected errors %v", tt.src, errs)
+			continue
+		}
+		if len(tokens) != 1 || tokens[0].kind != tt.kind || tokens[0].val != tt.val {
+			t.Errorf("%q: got %v, want a single %v %q", tt.src, tokens, tt.kind.ToString(), tt.val)
+		}
+	}
+}
+
+func TestLexerInvalidUTF8(t *testing.T) {
+	tokens, errs := lex(t, "let x\xff = \xfe1")
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %d: %v", len(errs), errs)
+	}
+	for i, want := range []string{"Invalid UTF-8 byte 0xff", "Invalid UTF-8 byte 0xfe"} {
+		if errs[i].Msg != want {
+			t.Errorf("got %q, want %q", errs[i].Msg, want)
+		}
+	}
+	if errs[1].Span.Col() != 10 {
+		t.Errorf("expected the second bad byte at column 10, got %v", errs[1].Span)
+	}
+	if len(tokens) != 4 {
+		t.Errorf("expected the tokens around the bad bytes, got %v", tokens)
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`"a\nb"`, "a\nb"},
+		{`"tab\there"`, "tab\there"},
+		{`"\"quoted\""`, `"quoted"`},
+		{`"back\\slash"`, `back\slash`},
+		{`"\{not interpolated\}"`, "{not interpolated}"},
+		{`"\u{48}\u{e9}\u{1F600}"`, "Hé😀"},
+		{"`raw \\n {kept}`", `raw \n {kept}`},
+		{"`two\nlines`", "two\nlines"},
+	}
+	for _, tt := range tests {
+		tokens, errs := lex(t, tt.src)
+		if len(errs) != 0 {
+			t.Errorf("%s: unexpected errors %v", tt.src, errs)
+			continue
+		}
+		if len(tokens) != 1 || tokens[0].kind != String || tokens[0].val != tt.want {
+			t.Errorf("%s: got %v, want %q", tt.src, tokens, tt.want)
+		}
+	}
+}
+
+func TestStringErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		col  int
+		msg  string
+	}{
+		{"bad escape", `"a\qb"`, 3, `Unknown escape sequence \q`},
+		{"unterminated", "\"abc\nprint(1)", 1, "Unterminated string literal"},
+		{"unterminated at eof", `"abc`, 1, "Unterminated string literal"},
+		{"unterminated raw", "`abc", 1, "Unterminated raw string literal"},
+		{"unicode without brace", `"\u48"`, 2, `Expected '{' after \u`},
+		{"unterminated unicode", `"\u{48"`, 2, `Unterminated unicode escape, expected '}'`},
+		{"invalid unicode", `"\u{110000}"`, 2, `Invalid unicode escape \u{110000}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, errs := lex(t, tt.src)
+			if len(errs) != 1 {
+				t.Fatalf("expected 1 error, got %d: %v", len(errs), errs)
+			}
+			if errs[0].Msg != tt.msg || errs[0].Span.Col() != tt.col {
+				t.Errorf("got %v: %q, want 1:%d: %q", errs[0].Span, errs[0].Msg, tt.col, tt.msg)
+			}
+		})
+	}
+}
+
+func TestLexInterpolation(t *testing.T) {
+	tokens, errs := lex(t, `"a{x + 1}b"`)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	kinds := []tokenKind{InterpStart, String, LBrace, Identifier, Plus, Literal, RBrace, String, InterpEnd}
+	if len(tokens) != len(kinds) {
+		t.Fatalf("expected %d tokens, got %d: %v", len(kinds), len(tokens), tokens)
+	}
+	for i, tk := range tokens {
+		if tk.kind != kinds[i] {
+			t.Errorf("token %d: got %v, want %v", i, tk.kind.ToString(), kinds[i].ToString())
+		}
+	}
+	// the string is unterminated too, but the interpolation is reported first
+	_, errs = lex(t, `"a{x`)
+	if len(errs) == 0 || errs[0].Msg != "Unterminated interpolation, expected '}'" {
+		t.Errorf("expected an unterminated interpolation, got %v", errs)
+	}
+}
+
+func TestParseIntLiteral(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+		err  string
+	}{
+		{"42", 42, ""},
+		{"1_000_000", 1000000, ""},
+		{"0xff", 255, ""},
+		{"0XFF_FF", 65535, ""},
+		{"0b1010", 10, ""},
+		{"0o17", 15, ""},
+		{"0x7fffffffffffffff", 9223372036854775807, ""},
+		{"0x8000000000000000", 0, "Integer literal 0x8000000000000000 overflows int"},
+		{"99999999999999999999", 0, "Integer literal 99999999999999999999 overflows int"},
+		{"0x", 0, "Missing digits in literal 0x"},
+		{"0b102", 0, "Invalid digit in base 2 literal 0b102"},
+		{"12abc", 0, "Invalid digit in base 10 literal 12abc"},
+		{"1__0", 0, "Invalid digit separator in literal 1__0"},
+		{"0x_ff", 0, "Invalid digit separator in literal 0x_ff"},
+		{"10_", 0, "Invalid digit separator in literal 10_"},
+	}
+	for _, tt := range tests {
+		got, err := parseIntLiteral(tt.text)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: got %v, want %q", tt.text, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %d, %v, want %d", tt.text, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseFloatLiteral(t *testing.T) {
+	tests := []struct {
+		text string
+		want float64
+		err  string
+	}{
+		{"1.5", 1.5, ""},
+		{"1_000.25", 1000.25, ""},
+		{"1e3", 1000, ""},
+		{"2.5E-2", 0.025, ""},
+		{"1e999", 0, "Float literal 1e999 overflows float"},
+		{"1._5", 0, "Invalid digit separator in literal 1._5"},
+		{"1.5x", 0, "Invalid float literal 1.5x"},
+	}
+	for _, tt := range tests {
+		got, err := parseFloatLiteral(tt.text)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: got %v, want %q", tt.text, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %v, %v, want %v", tt.text, got, err, tt.want)
+		}
+	}
+}
+
+func TestLexNumbers(t *testing.T) {
+	tokens, errs := lex(t, "0xff 1.5e3 3.x 0x1ffffffffffffffff")
+	kinds := []tokenKind{Literal, FloatLit, Literal, Period, Identifier, Literal}
+	if len(tokens) != len(kinds) {
+		t.Fatalf("expected %d tokens, got %d: %v", len(kinds), len(tokens), tokens)
+	}
+	for i, tk := range tokens {
+		if tk.kind != kinds[i] {
+			t.Errorf("token %d: got %v, want %v", i, tk.kind.ToString(), kinds[i].ToString())
+		}
+	}
+	// an overflowing literal is still one token, reported once
+	if len(errs) != 1 || errs[0].Msg != "Integer literal 0x1ffffffffffffffff overflows int" || errs[0].Span.Col() != 16 {
+		t.Errorf("expected an overflow at column 16, got %v", errs)
+	}
+}
+
+func TestComments(t *testing.T) {
+	tokens, errs := lex(t, `
+let a = 1 // trailing
+/* block */ let b = 2
+/* outer /* nested */ still a comment */
+//// four slashes is a plain comment
+let c = 3 /* multi
+line */
+`)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if len(tokens) != 12 {
+		t.Errorf("expected only the three lets, got %d tokens: %v", len(tokens), tokens)
+	}
+	for _, tk := range tokens {
+		if tk.kind == DocComment {
+			t.Errorf("unexpected doc comment %q", tk.val)
+		}
+	}
+	_, errs = lex(t, "let a = 1\n/* a /* b */\nlet b = 2")
+	if len(errs) != 1 || errs[0].Msg != "Unterminated block comment" || errs[0].Span.Line() != 2 {
+		t.Errorf("expected an unterminated block comment on line 2, got %v", errs)
+	}
+}
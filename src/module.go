@@ -0,0 +1,154 @@
+package src
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Module is one file of a program. Name is its path relative to the entry
+// file without the extension, e.g. `lib/util`, and prefixes the labels of its
+// functions. The entry module's Name is empty
+type Module struct {
+	Name       string
+	Path       string
+	Statements []Node
+	Imports    map[*ImportDecl]*Module // for each top level import
+	Deps       []*Module               // the imported modules, in the order they were imported
+}
+
+// Loader reads a program and every module it imports into one AST. Each file
+// is parsed once however many modules import it
+type Loader struct {
+	dir     string             // of the entry file, module names are relative to it
+	modules map[string]*Module // by absolute path
+	order   []*Module          // each after the modules it imports
+	loading []*Module          // the chain of imports being loaded, to report cycles
+	inputs  map[string]string  // the source of each file, to render diagnostics
+	spans   map[Node]Span
+	errors  []error
+}
+
+func NewLoader() *Loader {
+	return &Loader{
+		modules: make(map[string]*Module),
+		inputs:  make(map[string]string),
+		spans:   make(map[Node]Span),
+	}
+}
+
+// Load reads the program lexer holds, the errors are LexErrors, ParseErrors
+// and ImportErrors from any of its files
+func (l *Loader) Load(lexer *Lexer) (*AST, []error) {
+	l.dir = filepath.Dir(lexer.file)
+	l.load(lexer, "")
+	statements := []Node{}
+	for _, mod := range l.order {
+		statements = append(statements, mod.Statements...)
+	}
+	return &AST{Root: &Program{Statements: statements}, Spans: l.spans, Modules: l.order}, l.errors
+}
+
+// Print renders a diagnostic from any stage against the file it points into
+func (l *Loader) Print(err error) string {
+	switch e := err.(type) {
+	case LexError:
+		return renderDiagnostic(l.inputs[e.Span.file], e.Span, e.Msg)
+	case ParseError:
+		return renderDiagnostic(l.inputs[e.Span.file], e.Span, e.Msg)
+	case ImportError:
+		return renderDiagnostic(l.inputs[e.Span.file], e.Span, e.Msg)
+	case NameError:
+		return renderDiagnostic(l.inputs[e.Span.file], e.Span, e.Msg)
+	case TypeError:
+		return renderDiagnostic(l.inputs[e.Span.file], e.Span, e.Msg)
+	case Warning:
+		return renderDiagnostic(l.inputs[e.Span.file], e.Span, "warning: "+e.Msg)
+	default:
+		return err.Error()
+	}
+}
+
+// load parses one file and then the modules it imports, so it is added to
+// the order after them
+func (l *Loader) load(lexer *Lexer, name string) *Module {
+	mod := &Module{Name: name, Path: lexer.file, Imports: make(map[*ImportDecl]*Module)}
+	l.modules[absPath(lexer.file)] = mod
+	l.inputs[lexer.file] = lexer.src
+	parser, lexErrs := lexer.Tokenize()
+	for _, err := range lexErrs {
+		l.errors = append(l.errors, err)
+	}
+	if len(lexErrs) > 0 {
+		l.order = append(l.order, mod)
+		return mod
+	}
+	ast, parseErrs := parser.Parse()
+	for _, err := range parseErrs {
+		l.errors = append(l.errors, err)
+	}
+	for node, span := range ast.Spans {
+		l.spans[node] = span
+	}
+	mod.Statements = ast.Root.(*Program).Statements
+	l.loading = append(l.loading, mod)
+	for _, stmt := range mod.Statements {
+		if imp, ok := stmt.(*ImportDecl); ok {
+			if dep := l.importModule(mod, imp); dep != nil {
+				mod.Imports[imp] = dep
+				mod.Deps = append(mod.Deps, dep)
+			}
+		}
+	}
+	l.loading = l.loading[:len(l.loading)-1]
+	l.order = append(l.order, mod)
+	return mod
+}
+
+// importModule finds the module imp names relative to the file importing it,
+// loading it the first time it is imported
+func (l *Loader) importModule(from *Module, imp *ImportDecl) *Module {
+	file := imp.Path
+	if filepath.Ext(file) == "" {
+		file += ".ayc"
+	}
+	path := filepath.Join(filepath.Dir(from.Path), file)
+	if dep, ok := l.modules[absPath(path)]; ok {
+		if i := slices.Index(l.loading, dep); i >= 0 {
+			chain := []string{}
+			for _, mod := range l.loading[i:] {
+				chain = append(chain, filepath.Base(mod.Path))
+			}
+			chain = append(chain, filepath.Base(dep.Path))
+			l.errorAt(imp, fmt.Sprintf("Import cycle: %s", strings.Join(chain, " -> ")))
+			return nil
+		}
+		if slices.Contains(from.Deps, dep) {
+			l.errorAt(imp, fmt.Sprintf("%s is already imported", imp.Path))
+			return nil
+		}
+		return dep
+	}
+	lexer, err := NewLexer(path)
+	if err != nil {
+		l.errorAt(imp, fmt.Sprintf("Cannot import %s, %s doesn't exist", imp.Path, filepath.Clean(file)))
+		return nil
+	}
+	name, err := filepath.Rel(l.dir, path)
+	if err != nil {
+		name = path
+	}
+	return l.load(lexer, filepath.ToSlash(strings.TrimSuffix(name, filepath.Ext(name))))
+}
+
+func (l *Loader) errorAt(node Node, msg string) {
+	l.errors = append(l.errors, ImportError{Span: l.spans[node], Msg: msg})
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,99 @@
+package src
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// loadFiles writes files into a temporary directory and loads main.ayc from
+// it, returning the first error any stage reports
+func loadFiles(t *testing.T, files map[string]string) error {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lexer, err := NewLexer(filepath.Join(dir, "main.ayc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frontend(t, lexer).first()
+}
+
+func TestModuleErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		msg   string
+	}{
+		{"missing", map[string]string{
+			"main.ayc": `import "nope"`,
+		}, "Cannot import nope, nope.ayc doesn't exist"},
+		{"cycle", map[string]string{
+			"main.ayc":  `import "a"`,
+			"a.ayc":     `import "lib/b"`,
+			"lib/b.ayc": `import "../a"`,
+		}, "Import cycle: a.ayc -> b.ayc -> a.ayc"},
+		{"imports itself", map[string]string{
+			"main.ayc": `import "main"`,
+		}, "Import cycle: main.ayc -> main.ayc"},
+		{"imported twice", map[string]string{
+			"main.ayc": "import \"a\"\nimport \"a.ayc\"",
+			"a.ayc":    `pub let x = 1`,
+		}, "a.ayc is already imported"},
+		{"private function", map[string]string{
+			"main.ayc": "import \"a\"\nprint(f())",
+			"a.ayc":    "def f() -> int {\n\treturn 1\n}",
+		}, "f is not exported by a"},
+		{"private variable", map[string]string{
+			"main.ayc": "import \"a\"\nprint(x)",
+			"a.ayc":    `let x = 1`,
+		}, "x is not exported by a"},
+		{"not imported", map[string]string{
+			"main.ayc": "import \"a\"\nprint(x)",
+			"a.ayc":    "import \"b\"\npub let y = x",
+			"b.ayc":    `pub let x = 1`,
+		}, "Undeclared variable x"},
+		{"private struct", map[string]string{
+			"main.ayc": "import \"a\"\nlet p = P { x: 1 }",
+			"a.ayc":    `struct P { x: int }`,
+		}, "Unknown struct P"},
+		{"clashing imports", map[string]string{
+			"main.ayc": "import \"a\"\nimport \"b\"",
+			"a.ayc":    `pub let x = 1`,
+			"b.ayc":    `pub let x = 2`,
+		}, "x is already imported from a"},
+		{"declaration clashes", map[string]string{
+			"main.ayc": "import \"a\"\nlet x = 2",
+			"a.ayc":    `pub let x = 1`,
+		}, "x is already imported from a"},
+		{"struct of the same name", map[string]string{
+			"main.ayc": "import \"lib\"\nstruct Point { name: str }\nprint(sum(Point { name: \"a\" }))",
+			"lib.ayc":  "pub struct Point { x: int, y: int }\npub def sum(p: Point) -> int {\n\treturn p.x + p.y\n}",
+		}, "Argument p of sum has type lib.Point, got Point"},
+		{"nested pub", map[string]string{
+			"main.ayc": "def f() -> int {\n\tpub let x = 1\n\treturn x\n}",
+		}, "Only top level declarations can be exported"},
+		{"nested import", map[string]string{
+			"main.ayc": "if true {\n\timport \"a\"\n}",
+		}, "Imports can only be at the top level"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadFiles(t, tt.files)
+			if err == nil {
+				t.Fatalf("expected %q", tt.msg)
+			}
+			if err.Error() != tt.msg {
+				t.Errorf("got %q, want %q", err.Error(), tt.msg)
+			}
+		})
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"log/slog"
 	"slices"
-	"strconv"
 	"strings"
 )
 
@@ -49,7 +48,8 @@ func (s *Stack[T]) Len() int {
 
 func isBinaryOperator(tk tokenKind) bool {
 	switch tk {
-	case Plus, Minus, Mul, Div, Mod, Gte, Gt, Lt, Lte, And, Or, Neq, LShift, RShift:
+	case Plus, Minus, Mul, Div, Mod, Gte, Gt, Lt, Lte, And, Or, Neq, EqEq,
+		LShift, RShift, BitAnd, BitOr, BitXor:
 		return true
 	default:
 		return false
@@ -57,12 +57,13 @@ func isBinaryOperator(tk tokenKind) bool {
 }
 
 type Parser struct {
-	isRepl   bool
-	input    string
-	tokens   []Token
-	pos      int
-	currFunc *FuncDef
-	Ast      *AST
+	input  string
+	tokens []Token
+	pos    int
+	docs   map[int]string // doc comments keyed by the index of the token they precede
+	spans  map[Node]Span
+	errors []ParseError
+	Ast    *AST
 }
 
 /*
@@ -74,12 +75,16 @@ func (parser *Parser) current() *Token {
 	if parser.pos < len(parser.tokens) {
 		return &parser.tokens[parser.pos]
 	} else {
-		return &Token{kind: EOF, val: ""}
+		// the lexer always ends the stream with an EOF token
+		return &parser.tokens[len(parser.tokens)-1]
 	}
 }
 
 func (parser *Parser) peek() *Token {
-	return &parser.tokens[parser.pos+1]
+	if parser.pos+1 < len(parser.tokens) {
+		return &parser.tokens[parser.pos+1]
+	}
+	return &parser.tokens[len(parser.tokens)-1]
 }
 
 func (parser *Parser) next() *Token {
@@ -87,67 +92,208 @@ func (parser *Parser) next() *Token {
 	return parser.current()
 }
 
-func (par *Parser) PrintError(tk *Token, expected tokenKind) string {
-	line := tk.span.line
-	errMsg := ""
-	if expected == EOF {
-		errMsg = fmt.Sprintf("Unexpected token %v on line %d", tk.kind.ToString(), line)
-	} else {
-		errMsg = fmt.Sprintf("Expected %v, got %v on line %d", expected.ToString(), tk.kind.ToString(), line)
+func (par *Parser) PrintParseError(err ParseError) string {
+	return renderDiagnostic(par.input, err.Span, err.Msg)
+}
+
+func (par *Parser) PrintLexError(err LexError) string {
+	return renderDiagnostic(par.input, err.Span, err.Msg)
+}
+
+func (par *Parser) PrintTypeError(err TypeError) string {
+	return renderDiagnostic(par.input, err.Span, err.Msg)
+}
+
+func (par *Parser) PrintNameError(err NameError) string {
+	return renderDiagnostic(par.input, err.Span, err.Msg)
+}
+
+func (par *Parser) PrintWarning(w Warning) string {
+	return renderDiagnostic(par.input, w.Span, "warning: "+w.Msg)
+}
+
+func (par *Parser) errorAt(tk *Token, msg string) error {
+	// only report the first error at a token, the rest just cascade from it
+	if len(par.errors) > 0 && par.errors[len(par.errors)-1].Span == tk.span {
+		return par.errors[len(par.errors)-1]
 	}
-	relevantCode := strings.Split(par.input, "\n")[line-1]
-	return fmt.Sprintf(`%s
-		%s
-		%s%s^^^^^^^^^^^^%s`, errMsg, relevantCode, strings.Repeat(" ", tk.span.len), Red, Reset)
+	err := ParseError{Span: tk.span, Msg: msg}
+	par.errors = append(par.errors, err)
+	return err
 }
 
-func (par *Parser) assertToken(tk *Token, expected tokenKind, err ...string) error {
+func (par *Parser) unexpected(tk *Token) error {
+	if tk.kind == EOF {
+		return par.errorAt(tk, "Unexpected end of file")
+	}
+	return par.errorAt(tk, fmt.Sprintf("Unexpected token %v", tk.kind.ToString()))
+}
+
+func (par *Parser) assertToken(tk *Token, expected tokenKind, hint ...string) error {
 	if tk.kind != expected {
-		fmt.Println(par.PrintError(tk, expected))
-		fmt.Println(err)
-		if !par.isRepl {
-			panic(fmt.Sprintf("Expected %v, got %v", expected, tk.kind))
+		msg := fmt.Sprintf("Expected %v, got %v", expected.ToString(), tk.kind.ToString())
+		if len(hint) > 0 && hint[0] != "" {
+			msg += ". " + hint[0]
 		}
-		return fmt.Errorf("Expected %v, got %v", expected, tk.kind)
+		return par.errorAt(tk, msg)
 	}
 	return nil
 }
 
+// isStatementStart reports whether a token can only begin a new statement
+func isStatementStart(tk tokenKind) bool {
+	switch tk {
+	case Let, Defn, Struct, If, For, While, Return, Print, Import, Pub:
+		return true
+	default:
+		return false
+	}
+}
+
+// synchronize skips the rest of a broken statement that began at token index
+// start. It stops at a `}`, a statement keyword or the first token on a later
+// line than the error, always consuming at least one token. A block opened on
+// the way is skipped as a whole, so a bad function header drops its body too.
+func (par *Parser) synchronize(start int) {
+	errLine := par.errors[len(par.errors)-1].Span.line
+	for par.current().kind != EOF {
+		tk := par.current()
+		if par.pos > start && (tk.kind == RBrace || isStatementStart(tk.kind) || tk.span.line > errLine) {
+			return
+		}
+		if tk.kind == LBrace {
+			par.skipBlock()
+			return
+		}
+		par.next()
+	}
+}
+
+// skipBlock skips from a `{` past its matching `}`
+func (par *Parser) skipBlock() {
+	depth := 0
+	for par.current().kind != EOF {
+		switch par.current().kind {
+		case LBrace:
+			depth++
+		case RBrace:
+			depth--
+		}
+		par.next()
+		if depth == 0 {
+			return
+		}
+	}
+}
+
+// parseStatementOrRecover parses one statement, if it produced any errors the
+// statement is dropped and the parser skips ahead to the next one
+func (par *Parser) parseStatementOrRecover() Node {
+	start := par.pos
+	errCount := len(par.errors)
+	stmt := par.parseStatement()
+	if len(par.errors) > errCount {
+		par.synchronize(start)
+		return nil
+	}
+	return stmt
+}
+
 func NewParser(lxr *Lexer) *Parser {
+	tokens, docs := collectDocComments(lxr.tokens)
 	parser := &Parser{
-		input:    lxr.src,
-		tokens:   lxr.tokens,
-		pos:      0,
-		Ast:      nil,
-		currFunc: nil,
+		input:  lxr.src,
+		tokens: tokens,
+		pos:    0,
+		Ast:    nil,
+		docs:   docs,
+		spans:  make(map[Node]Span),
 	}
 	return parser
 }
 
-func (par *Parser) Parse() *AST {
+// collectDocComments pulls `///` comments out of the token stream, so they can
+// never trip up the parser, and joins consecutive lines into a single doc string
+func collectDocComments(tokens []Token) ([]Token, map[int]string) {
+	filtered := []Token{}
+	docs := make(map[int]string)
+	pending := []string{}
+	for _, tk := range tokens {
+		if tk.kind == DocComment {
+			pending = append(pending, tk.val)
+			continue
+		}
+		if len(pending) > 0 {
+			docs[len(filtered)] = strings.Join(pending, "\n")
+			pending = pending[:0]
+		}
+		filtered = append(filtered, tk)
+	}
+	return filtered, docs
+}
+
+// Parse returns the AST for every statement that parsed cleanly, along with
+// all of the errors found in the rest
+func (par *Parser) Parse() (*AST, []ParseError) {
 	var statements []Node
 	for par.current().kind != EOF {
-		stmt := par.parseStatement()
-		if stmt != nil {
+		if stmt := par.parseStatementOrRecover(); stmt != nil {
 			statements = append(statements, stmt)
-		} else {
-			break
 		}
 	}
-	par.Ast = &AST{Root: &Program{Statements: statements}}
-	return par.Ast
+	par.Ast = &AST{Root: &Program{Statements: statements}, Spans: par.spans}
+	return par.Ast, par.errors
 }
 
 func (par *Parser) parseStatement() Node {
+	start := par.current()
+	stmt := par.parseStatementKind()
+	par.setSpan(stmt, start)
+	return stmt
+}
+
+// setSpan records the source range of a node, from the start token up to
+// the last token consumed, so later passes can point their errors at it.
+// The first span recorded wins, so `(x)` keeps pointing at just the x
+func (par *Parser) setSpan(node Node, start *Token) {
+	if node == nil || par.pos == 0 {
+		return
+	}
+	if _, ok := par.spans[node]; ok {
+		return
+	}
+	span := start.span
+	end := par.tokens[par.pos-1].span
+	span.endLine, span.endCol = end.endLine, end.endCol
+	par.spans[node] = span
+}
+
+func (par *Parser) parseStatementKind() Node {
 	switch par.current().kind {
 	case Let:
 		return par.parseDeclaration()
 	case Print:
 		return par.parsePrintStatement()
 	case Identifier:
-		return par.parseExpression(0)
+		if isAssignOp(par.peek().kind) {
+			return par.parseAssignment()
+		}
+		expr := par.parseExpression(0)
+		if isAssignOp(par.current().kind) {
+			switch target := expr.(type) {
+			case *IndexExpr, *FieldExpr:
+				return par.parseTargetAssignment(target)
+			}
+		}
+		return expr
 	case Defn:
 		return par.parseFunctionDef()
+	case Struct:
+		return par.parseStructDef()
+	case Import:
+		return par.parseImport()
+	case Pub:
+		return par.parseExported()
 	case Return:
 		return par.parseReturnStatement()
 	case If:
@@ -156,15 +302,50 @@ func (par *Parser) parseStatement() Node {
 		return par.parseBlock()
 	case For:
 		return par.parseForLoop()
+	case While:
+		return par.parseWhileLoop()
 	case EOF:
 		return nil
 	default:
-		if !par.isRepl {
-			panic(fmt.Sprintf("Unexpected token: %v", par.current().val))
-		} else {
-			par.PrintError(par.current(), EOF)
-			return nil
-		}
+		par.unexpected(par.current())
+		return nil
+	}
+}
+
+// parseImport parses `import "path"`, the Loader reads the module it names
+func (par *Parser) parseImport() Node {
+	par.next() // import
+	if err := par.assertToken(par.current(), String, `Expected the path of a module, e.g. import "util"`); err != nil {
+		return nil
+	}
+	path := par.current().val
+	par.next()
+	return &ImportDecl{Path: path}
+}
+
+// parseExported parses a declaration marked `pub`
+func (par *Parser) parseExported() Node {
+	pub := par.current()
+	// a doc comment is attached to the token after it, which is `pub`
+	doc := par.docs[par.pos]
+	par.next()
+	switch node := par.parseStatementKind().(type) {
+	case *FuncDef:
+		node.Pub = true
+		node.Doc = doc
+		return node
+	case *StructDef:
+		node.Pub = true
+		node.Doc = doc
+		return node
+	case *LetExpr:
+		node.Pub = true
+		return node
+	case nil:
+		return nil
+	default:
+		par.errorAt(pub, "Only functions, structs and variables can be exported")
+		return nil
 	}
 }
 
@@ -175,54 +356,17 @@ func (par *Parser) parsePrintStatement() Node {
 }
 
 func (par *Parser) parseReturnStatement() Node {
+	ret := par.current()
 	par.next()
+	// a bare `return` leaves a void function early
+	if next := par.current(); next.kind == RBrace || next.kind == EOF || next.span.line > ret.span.line {
+		return &ReturnExpr{}
+	}
 	slog.Debug("Parsing return statement. Current token: ", slog.String("token", par.current().kind.ToString()))
 	expr := par.parseExpression(0)
-	par.evalReturnType(&expr)
 	return &ReturnExpr{Value: expr}
 }
 
-func (par *Parser) evalReturnType(expr *Expr) {
-	switch ex := (*expr).(type) {
-	case *CallExpr:
-		if ex.Function.Name == par.currFunc.Name.Name {
-			ex.IsTail = true
-			ex.IsRecursive = true
-		}
-	case *BinaryExpr:
-		if call, ok := ex.Right.(*CallExpr); ok {
-			call.IsTail = true
-			if call.Function.Name == par.currFunc.Name.Name {
-				call.IsRecursive = true
-			}
-		}
-		if call, ok := ex.Left.(*CallExpr); ok {
-			call.IsTail = true
-			if call.Function.Name == par.currFunc.Name.Name {
-				call.IsRecursive = true
-			}
-		}
-	case *Ident:
-		if slices.ContainsFunc(par.currFunc.Params, func(p FnParam) bool {
-			return p.Name == ex.Name && p.Type != par.currFunc.RetType
-		}) {
-			panic(fmt.Sprintf("Expected return type %v, got %v", par.currFunc.RetType, ex.Name))
-		}
-	case *NumLiteral:
-		if par.currFunc.RetType != Int {
-			panic(fmt.Sprintf("Expected return type %v, got %v", par.currFunc.RetType, ex.Value))
-		}
-	case *StringLiteral:
-		if par.currFunc.RetType != String {
-			panic(fmt.Sprintf("Expected return type %v, got %v", par.currFunc.RetType, ex.string))
-		}
-	case *BoolLiteral:
-		if par.currFunc.RetType != Bool {
-			panic(fmt.Sprintf("Expected return type %v, got %v", par.currFunc.RetType, ex.bool))
-		}
-	}
-}
-
 func (par *Parser) parseDeclaration() Node {
 	slog.Debug("Parsing declaration. Current token: ", slog.String("token", par.current().kind.ToString()))
 	par.next()
@@ -231,17 +375,46 @@ func (par *Parser) parseDeclaration() Node {
 		return nil
 	}
 	par.next()
+	var typ Type
+	if par.current().kind == Colon {
+		par.next()
+		start := par.current()
+		if typ = par.parseType(); typ == nil {
+			return nil
+		}
+		if typ == VoidType {
+			par.errorAt(start, "Expected type, got Void")
+			return nil
+		}
+	}
 	if err := par.assertToken(par.current(), Eq, ""); err != nil {
 		return nil
 	}
 	par.next()
+	value := par.parseExpression(0)
+	if value == nil {
+		return nil
+	}
 	return &LetExpr{
 		Variable: Ident{Name: ident.val},
-		Value:    par.parseExpression(0),
+		Type:     typ,
+		Value:    value,
 	}
 }
 
 func (par *Parser) parseFunctionCall(fName string) *CallExpr {
+	args, ok := par.parseCallArgs()
+	if !ok {
+		return nil
+	}
+	return &CallExpr{
+		Function: Ident{Name: fName},
+		Args:     args,
+	}
+}
+
+// parseCallArgs parses the parenthesized arguments of a call
+func (par *Parser) parseCallArgs() (FuncArgs, bool) {
 	par.next()
 	var args []FuncArg
 	for par.current().kind != RParen && par.current().kind != EOF {
@@ -254,47 +427,150 @@ func (par *Parser) parseFunctionCall(fName string) *CallExpr {
 		}
 	}
 	if err := par.assertToken(par.current(), RParen); err != nil {
+		return FuncArgs{}, false
+	}
+	par.next()
+	return FuncArgs{args}, true
+}
+
+func isType(tk tokenKind) bool {
+	return slices.Contains([]tokenKind{Int, Float, String, Bool, Void}, tk)
+}
+
+// parseType parses a type annotation, one of the builtin types, an array
+// written `[elem]`, a map, a function or the name of a struct
+func (par *Parser) parseType() Type {
+	token := par.current()
+	if token.kind == Fn {
+		return par.parseFuncType()
+	}
+	if token.kind == Identifier && token.val == "map" {
+		// `map` is only special in a type, so it can still be used as a name
+		par.next()
+		if err := par.assertToken(par.current(), LBracket, "Map types are written map[key]value"); err != nil {
+			return nil
+		}
+		par.next()
+		key := par.parseType()
+		if key == nil {
+			return nil
+		}
+		if err := par.assertToken(par.current(), RBracket); err != nil {
+			return nil
+		}
+		par.next()
+		value := par.parseType()
+		if value == nil {
+			return nil
+		}
+		return &MapType{Key: key, Value: value}
+	}
+	if token.kind == Identifier {
+		// only the name is known here, the Checker looks up the fields
+		par.next()
+		return &StructType{Name: token.val}
+	}
+	if token.kind == LBracket {
+		par.next()
+		start := par.current()
+		elem := par.parseType()
+		if elem == nil {
+			return nil
+		}
+		if elem == VoidType {
+			par.errorAt(start, "Arrays can't hold void")
+			return nil
+		}
+		if err := par.assertToken(par.current(), RBracket); err != nil {
+			return nil
+		}
+		par.next()
+		return &ArrayType{Elem: elem}
+	}
+	if !isType(token.kind) {
+		par.errorAt(token, fmt.Sprintf("Expected type, got %v", token.kind.ToString()))
 		return nil
 	}
+	par.next()
+	return basicType(token.kind)
+}
 
+// parseFuncType parses `fn(int, str) -> bool`
+func (par *Parser) parseFuncType() Type {
+	par.next() // fn
+	if err := par.assertToken(par.current(), LParen, "Function types are written fn(params) -> ret"); err != nil {
+		return nil
+	}
+	par.next()
+	sig := &FuncType{Params: []Type{}}
+	for par.current().kind != RParen && par.current().kind != EOF {
+		param := par.parseType()
+		if param == nil {
+			return nil
+		}
+		sig.Params = append(sig.Params, param)
+		if par.current().kind != Comma {
+			break
+		}
+		par.next()
+	}
+	if err := par.assertToken(par.current(), RParen); err != nil {
+		return nil
+	}
 	par.next()
-	isRecursive := false
-	if par.currFunc != nil && par.currFunc.Name.Name == fName {
-		isRecursive = true
+	if err := par.assertToken(par.current(), Arrow, "Function types are written fn(params) -> ret"); err != nil {
+		return nil
 	}
-	return &CallExpr{
-		Function:    Ident{Name: fName},
-		Args:        FuncArgs{args},
-		IsRecursive: isRecursive,
+	par.next()
+	if sig.Ret = par.parseType(); sig.Ret == nil {
+		return nil
 	}
+	return sig
 }
 
-func isType(tk tokenKind) bool {
-	return slices.Contains([]tokenKind{Int, String, Bool, Void}, tk)
+// parseLambda parses `fn(x: int) -> int { ... }` in expression position
+func (par *Parser) parseLambda() Expr {
+	par.next() // fn
+	params := par.parseFuncParams()
+	if params == nil {
+		return nil
+	}
+	if err := par.assertToken(par.current(), Arrow); err != nil {
+		return nil
+	}
+	par.next()
+	retType := par.parseType()
+	if retType == nil {
+		return nil
+	}
+	if err := par.assertToken(par.current(), LBrace); err != nil {
+		return nil
+	}
+	body := par.parseBlock().(*Block)
+	return &Lambda{Params: params, Body: body, RetType: retType}
 }
 
 func (par *Parser) parseFunctionDef() Node {
+	doc := par.docs[par.pos]
 	par.next()
 	if err := par.assertToken(par.current(), Identifier); err != nil {
 		return nil
 	}
 	fName := par.current().val
-	par.currFunc = &FuncDef{
-		Name: Ident{fName},
-	}
 	par.next()
 	params := par.parseFuncParams()
+	if params == nil {
+		return nil
+	}
 	if err := par.assertToken(par.current(), Arrow); err != nil {
 		return nil
 	}
 	par.next() // ->
-	retType := par.current().kind
-	par.currFunc.RetType = retType
 	slog.Debug("Parsing function definition", slog.String("currentToken", par.current().kind.ToString()))
-	if !isType(retType) {
-		panic(fmt.Sprintf("Expected type, got %v", par.current().kind))
+	retType := par.parseType()
+	if retType == nil {
+		return nil
 	}
-	par.next()
 	if err := par.assertToken(par.current(), LBrace); err != nil {
 		return nil
 	}
@@ -304,57 +580,216 @@ func (par *Parser) parseFunctionDef() Node {
 		Params:  params,
 		Body:    body,
 		RetType: retType,
+		Doc:     doc,
 	}
 	def.Print()
-	par.currFunc = def
 	return def
 }
 
+// parseStructDef parses `struct Point { x: int, y: int }`, fields are
+// separated by commas or newlines
+func (par *Parser) parseStructDef() Node {
+	doc := par.docs[par.pos]
+	par.next() // struct
+	if err := par.assertToken(par.current(), Identifier, "Expected a struct name"); err != nil {
+		return nil
+	}
+	name := par.current()
+	par.next()
+	if err := par.assertToken(par.current(), LBrace); err != nil {
+		return nil
+	}
+	par.next()
+	fields := []StructField{}
+	for par.current().kind != RBrace && par.current().kind != EOF {
+		if err := par.assertToken(par.current(), Identifier, "Expected a field name"); err != nil {
+			return nil
+		}
+		field := par.current()
+		for _, f := range fields {
+			if f.Name == field.val {
+				par.errorAt(field, fmt.Sprintf("Field %s is already declared", field.val))
+				return nil
+			}
+		}
+		par.next()
+		if err := par.assertToken(par.current(), Colon, "Fields need a type"); err != nil {
+			return nil
+		}
+		par.next()
+		typ := par.parseType()
+		if typ == nil {
+			return nil
+		}
+		fields = append(fields, StructField{Name: field.val, Type: typ})
+		if par.current().kind == Comma {
+			par.next()
+		}
+	}
+	if err := par.assertToken(par.current(), RBrace); err != nil {
+		return nil
+	}
+	if len(fields) == 0 {
+		par.errorAt(name, fmt.Sprintf("Struct %s needs at least one field", name.val))
+		return nil
+	}
+	par.next()
+	return &StructDef{Name: Ident{Name: name.val}, Fields: fields, Doc: doc}
+}
+
+// isStructLiteral looks past the `{` after a name for `field:`, so the block
+// in `if ready { ... }` isn't mistaken for a struct literal
+func (par *Parser) isStructLiteral() bool {
+	if par.current().kind != LBrace || par.pos+2 >= len(par.tokens) {
+		return false
+	}
+	return par.tokens[par.pos+1].kind == Identifier && par.tokens[par.pos+2].kind == Colon
+}
+
+// parseStructLiteral parses the `{ x: 1, y: 2 }` after a struct name
+func (par *Parser) parseStructLiteral(name string) Expr {
+	par.next() // {
+	fields := []FieldInit{}
+	for par.current().kind != RBrace && par.current().kind != EOF {
+		if err := par.assertToken(par.current(), Identifier, "Expected a field name"); err != nil {
+			return nil
+		}
+		field := par.current().val
+		par.next()
+		if err := par.assertToken(par.current(), Colon); err != nil {
+			return nil
+		}
+		par.next()
+		value := par.parseExpression(0)
+		if value == nil {
+			return nil
+		}
+		fields = append(fields, FieldInit{Name: field, Value: value})
+		if par.current().kind != Comma {
+			break
+		}
+		par.next()
+	}
+	if err := par.assertToken(par.current(), RBrace, "Struct literals end with '}'"); err != nil {
+		return nil
+	}
+	par.next()
+	return &StructLit{Name: Ident{Name: name}, Fields: fields}
+}
+
 func (par *Parser) parseFuncParams() []FnParam {
 	if err := par.assertToken(par.current(), LParen); err != nil {
 		return nil
 	}
 	par.next()
 	params := []FnParam{}
+	starts := []*Token{}
 	for par.current().kind != RParen {
+		starts = append(starts, par.current())
 		argName := ""
-		if par.current().kind == Identifier {
-			argName = par.current().val
-			par.next()
+		if err := par.assertToken(par.current(), Identifier, "Expected a parameter name"); err != nil {
+			return nil
 		}
-		typ := Void
+		argName = par.current().val
+		par.next()
+		var typ Type
 		if par.current().kind == Colon {
 			par.next()
-			if !isType(par.current().kind) {
-				par.assertToken(par.current(), EOF, "Expected type, got %v", par.current().val)
+			if typ = par.parseType(); typ == nil {
 				return nil
 			}
-			typ = par.current().kind
-			par.next()
 		}
 		if par.current().kind == Comma {
 			par.next()
+		} else if par.current().kind != RParen {
+			par.assertToken(par.current(), RParen)
+			return nil
 		}
 		params = append(params, FnParam{Name: argName, Type: typ})
 	}
+	for i, start := range starts {
+		span := start.span
+		span.endCol = start.span.col + len([]rune(params[i].Name))
+		par.spans[&params[i]] = span
+	}
 	par.next() // )
 	return params
 }
 
-func (par *Parser) parseAssignment(left Expr) Expr {
+// compoundAssignOps maps each compound assignment to the operator it applies
+var compoundAssignOps = map[tokenKind]tokenKind{
+	PlusEq:   Plus,
+	MinusEq:  Minus,
+	MulEq:    Mul,
+	DivEq:    Div,
+	ModEq:    Mod,
+	LShiftEq: LShift,
+	RShiftEq: RShift,
+	BitAndEq: BitAnd,
+	BitOrEq:  BitOr,
+	BitXorEq: BitXor,
+}
+
+func isAssignOp(tk tokenKind) bool {
+	_, compound := compoundAssignOps[tk]
+	return compound || tk == Eq || tk == Incr || tk == Decr
+}
+
+// parseAssignment parses `x = e`, `x op= e`, `x++` and `x--`. The compound
+// forms are desugared, so `x += 1` becomes the same node as `x = x + 1`
+func (par *Parser) parseAssignment() Node {
+	ident := Ident{Name: par.current().val}
+	par.next()
 	op := par.current().kind
 	par.next()
-	right := par.parseExpression(5 - 1)
-	return &BinaryExpr{
-		Left:     left,
-		Operator: op,
-		Right:    right,
+	var value Expr
+	switch op {
+	case Incr:
+		value = &BinaryExpr{Left: &Ident{Name: ident.Name}, Operator: Plus, Right: &NumLiteral{Value: 1}}
+	case Decr:
+		value = &BinaryExpr{Left: &Ident{Name: ident.Name}, Operator: Minus, Right: &NumLiteral{Value: 1}}
+	default:
+		value = par.parseExpression(0)
+		if value == nil {
+			return nil
+		}
+		if binOp, ok := compoundAssignOps[op]; ok {
+			value = &BinaryExpr{Left: &Ident{Name: ident.Name}, Operator: binOp, Right: value}
+		}
 	}
+	return &ReAssignExpr{Variable: ident, NewValue: value}
+}
+
+// parseTargetAssignment parses the rest of `a[i] = e` or `p.x = e`,
+// desugaring the compound forms the same way parseAssignment does
+func (par *Parser) parseTargetAssignment(target Expr) Node {
+	op := par.current().kind
+	par.next()
+	var value Expr
+	switch op {
+	case Incr:
+		value = &BinaryExpr{Left: target, Operator: Plus, Right: &NumLiteral{Value: 1}}
+	case Decr:
+		value = &BinaryExpr{Left: target, Operator: Minus, Right: &NumLiteral{Value: 1}}
+	default:
+		value = par.parseExpression(0)
+		if value == nil {
+			return nil
+		}
+		if binOp, ok := compoundAssignOps[op]; ok {
+			value = &BinaryExpr{Left: target, Operator: binOp, Right: value}
+		}
+	}
+	if field, ok := target.(*FieldExpr); ok {
+		return &FieldAssign{Target: field.Target, Field: field.Field, Value: value}
+	}
+	index := target.(*IndexExpr)
+	return &IndexAssign{Target: index.Target, Index: index.Index, Value: value}
 }
 
 func (par *Parser) parseIfStatement() Node {
 	par.next()
-	cond := par.parseExpression(5)
+	cond := par.parseExpression(0)
 	slog.Debug("Parsing conditional expression", slog.Any("current", par.current()))
 	if err := par.assertToken(par.current(), LBrace); err != nil {
 		return nil
@@ -362,10 +797,19 @@ func (par *Parser) parseIfStatement() Node {
 	ifBlock := par.parseBlock()
 	if par.current().kind == Else {
 		par.next()
-		if err := par.assertToken(par.current(), LBrace); err != nil {
-			return nil
+		var elseBlk Node
+		if par.current().kind == If {
+			elif := par.parseIfStatement()
+			if elif == nil {
+				return nil
+			}
+			elseBlk = &Block{Statements: []Node{elif}}
+		} else {
+			if err := par.assertToken(par.current(), LBrace, "Expected a block or `if` after `else`"); err != nil {
+				return nil
+			}
+			elseBlk = par.parseBlock()
 		}
-		elseBlk := par.parseBlock()
 		return &IfStmt{
 			Condition: cond,
 			IfBlock:   ifBlock,
@@ -380,11 +824,31 @@ func (par *Parser) parseIfStatement() Node {
 }
 func (par *Parser) parseForLoop() Node {
 	par.next()
+	if par.current().kind == Identifier && par.peek().kind == Identifier && par.peek().val == "in" {
+		return par.parseForIn()
+	}
 	if err := par.assertToken(par.current(), LParen); err != nil {
 		return nil
 	}
 	par.next()
-	init := par.parseStatement()
+	loop := &ForLoop{}
+	start := par.pos
+	switch init := par.parseStatement().(type) {
+	case *LetExpr:
+		loop.Var = &Ident{Name: init.Variable.Name}
+		loop.Type = init.Type
+		loop.Start = init.Value
+		loop.IsDecl = true
+		// so warnings about the variable point at its name, not the `let`
+		par.spans[loop.Var] = par.tokens[start+1].span
+	case *ReAssignExpr:
+		loop.Var = &Ident{Name: init.Variable.Name}
+		loop.Start = init.NewValue
+		par.spans[loop.Var] = par.tokens[start].span
+	default:
+		par.errorAt(par.current(), "Expected the loop variable to be initialized")
+		return nil
+	}
 	if err := par.assertToken(par.current(), Semicolon); err != nil {
 		return nil
 	}
@@ -399,11 +863,63 @@ func (par *Parser) parseForLoop() Node {
 		return nil
 	}
 	par.next()
+	if err := par.assertToken(par.current(), LBrace); err != nil {
+		return nil
+	}
+	loop.Condition = cond
+	loop.Step = incr
+	loop.Body = par.parseBlock()
+	return loop
+}
+
+// parseForIn parses `for x in xs { }`. `in` is only special here, so it can
+// still be used as a name
+func (par *Parser) parseForIn() Node {
+	name := par.current()
+	par.next() // name
+	par.next() // in
+	iterable := par.parseExpression(0)
+	if iterable == nil {
+		return nil
+	}
+	if err := par.assertToken(par.current(), LBrace); err != nil {
+		return nil
+	}
+	loop := &ForIn{Var: &Ident{Name: name.val}, Iterable: iterable}
+	par.spans[loop.Var] = name.span
+	loop.Body = par.parseBlock()
+	return loop
+}
+
+// parseIfExpr parses `if cond then a else b`, both arms are required
+func (par *Parser) parseIfExpr() Expr {
+	par.next()
+	cond := par.parseExpression(0)
+	if err := par.assertToken(par.current(), Then, "An if expression is written `if cond then a else b`"); err != nil {
+		return nil
+	}
+	par.next()
+	then := par.parseExpression(0)
+	if err := par.assertToken(par.current(), Else, "An if expression needs an `else` arm"); err != nil {
+		return nil
+	}
+	par.next()
+	els := par.parseExpression(0)
+	if cond == nil || then == nil || els == nil {
+		return nil
+	}
+	return &IfExpr{Condition: cond, Then: then, Else: els}
+}
+
+func (par *Parser) parseWhileLoop() Node {
+	par.next()
+	cond := par.parseExpression(0)
+	if err := par.assertToken(par.current(), LBrace); err != nil {
+		return nil
+	}
 	body := par.parseBlock()
-	return &ForLoop{
-		Var:       init,
+	return &WhileLoop{
 		Condition: cond,
-		Step:      incr,
 		Body:      body,
 	}
 }
@@ -413,22 +929,12 @@ func (par *Parser) parseBlock() Node {
 	slog.Debug("Parsing block.", slog.String("curentToken:", par.current().kind.ToString()))
 	var statements []Node
 	for par.current().kind != RBrace {
-		stmt := par.parseStatement()
-		switch st := stmt.(type) {
-		case *ReturnExpr:
-			if call, ok := st.Value.(*CallExpr); ok {
-				call.IsTail = true
-				if call.Function.Name == par.currFunc.Name.Name {
-					call.IsRecursive = true
-				}
-			}
+		if par.current().kind == EOF {
+			par.assertToken(par.current(), RBrace, "You likely forgot a closing brace")
+			break
+		}
+		if stmt := par.parseStatementOrRecover(); stmt != nil {
 			statements = append(statements, stmt)
-		default:
-			if stmt != nil {
-				statements = append(statements, stmt)
-			} else {
-				break
-			}
 		}
 	}
 	par.next()
@@ -436,8 +942,14 @@ func (par *Parser) parseBlock() Node {
 }
 
 func (par *Parser) parseLiteral() Expr {
-	val, _ := strconv.Atoi(par.current().val)
+	// malformed or overflowing literals were already reported by the lexer
+	token := par.current()
 	par.next()
+	if token.kind == FloatLit {
+		val, _ := parseFloatLiteral(token.val)
+		return &FloatLiteral{Value: val}
+	}
+	val, _ := parseIntLiteral(token.val)
 	return &NumLiteral{Value: val}
 }
 
@@ -448,9 +960,8 @@ func (par *Parser) parseIdentifier() Expr {
 	if par.current().kind == LParen {
 		return par.parseFunctionCall(ident)
 	}
-	if par.current().kind == Eq {
-		// If the next token is '=', it's an assignment.
-		return par.parseAssignment(&Ident{Name: ident})
+	if par.isStructLiteral() {
+		return par.parseStructLiteral(ident)
 	}
 	return &Ident{Name: ident}
 }
@@ -468,6 +979,30 @@ func (par *Parser) parseGrouping() Expr {
 	return expr
 }
 
+func (par *Parser) parseInterpolation() Expr {
+	par.next() // InterpStart
+	parts := []Expr{}
+	for par.current().kind != InterpEnd && par.current().kind != EOF {
+		switch par.current().kind {
+		case String:
+			parts = append(parts, &StringLiteral{par.current().val})
+			par.next()
+		case LBrace:
+			par.next()
+			parts = append(parts, par.parseExpression(0))
+			if err := par.assertToken(par.current(), RBrace, "Interpolated expressions end with '}'"); err != nil {
+				return nil
+			}
+			par.next()
+		default:
+			par.unexpected(par.current())
+			return nil
+		}
+	}
+	par.next() // InterpEnd
+	return &InterpString{Parts: parts}
+}
+
 func (par *Parser) parseBinary(left Expr, op tokenKind) Expr {
 	precedence := precedence(op)
 	par.next()
@@ -479,6 +1014,73 @@ func (par *Parser) parseBinary(left Expr, op tokenKind) Expr {
 	}
 }
 
+// parseArrayLiteral parses `[a, b, c]`, a trailing comma is allowed
+func (par *Parser) parseArrayLiteral() Expr {
+	par.next() // [
+	items := []Expr{}
+	for par.current().kind != RBracket && par.current().kind != EOF {
+		item := par.parseExpression(0)
+		if item == nil {
+			return nil
+		}
+		items = append(items, item)
+		if par.current().kind != Comma {
+			break
+		}
+		par.next()
+	}
+	if err := par.assertToken(par.current(), RBracket, "Array literals end with ']'"); err != nil {
+		return nil
+	}
+	par.next()
+	return &Array{Items: items}
+}
+
+// parseIndex parses the `[i]` after an expression
+func (par *Parser) parseIndex(target Expr) Expr {
+	par.next() // [
+	index := par.parseExpression(0)
+	if index == nil {
+		return nil
+	}
+	if err := par.assertToken(par.current(), RBracket); err != nil {
+		return nil
+	}
+	par.next()
+	return &IndexExpr{Target: target, Index: index}
+}
+
+// parseMapLiteral parses `{k: v, ...}`, a trailing comma is allowed
+func (par *Parser) parseMapLiteral() Expr {
+	par.next() // {
+	lit := &MapLit{}
+	for par.current().kind != RBrace && par.current().kind != EOF {
+		key := par.parseExpression(0)
+		if key == nil {
+			return nil
+		}
+		if err := par.assertToken(par.current(), Colon, "Map entries are written key: value"); err != nil {
+			return nil
+		}
+		par.next()
+		value := par.parseExpression(0)
+		if value == nil {
+			return nil
+		}
+		lit.Keys = append(lit.Keys, key)
+		lit.Values = append(lit.Values, value)
+		if par.current().kind != Comma {
+			break
+		}
+		par.next()
+	}
+	if err := par.assertToken(par.current(), RBrace, "Map literals end with '}'"); err != nil {
+		return nil
+	}
+	par.next()
+	return lit
+}
+
 func (par *Parser) parseInputCall() Expr {
 	kind := par.current().kind
 	par.next() // consume 'input'
@@ -506,31 +1108,80 @@ func (par *Parser) parseExpression(prec int) Expr {
 	token := par.current()
 	var left Expr
 	switch token.kind {
-	case Literal:
+	case Literal, FloatLit:
 		left = par.parseLiteral()
 	case Identifier:
 		left = par.parseIdentifier()
 	case LParen:
 		left = par.parseGrouping()
-	case Minus:
-		operand := par.parseExpression(20)
-		left = &UnaryExpr{Operator: Minus, Operand: operand}
 	case String:
 		left = &StringLiteral{token.val}
 		par.next()
+	case True, False:
+		left = &BoolLiteral{token.kind == True}
+		par.next()
+	case InterpStart:
+		left = par.parseInterpolation()
+	case If:
+		left = par.parseIfExpr()
 	case InputInt, InputStr:
 		left = par.parseInputCall()
+	case LBracket:
+		left = par.parseArrayLiteral()
+	case LBrace:
+		left = par.parseMapLiteral()
+	case Fn:
+		left = par.parseLambda()
+	case Not:
+		par.next()
+		// `not` takes a whole comparison like in python
+		left = &UnaryExpr{Operator: Not, Operand: par.parseExpression(precedence(Not))}
 	default:
-		panic(fmt.Sprintf("Unexpected token: %v", token.val))
+		if !isUnaryOperator(token.kind) {
+			par.unexpected(token)
+			return nil
+		}
+		par.next()
+		// `-`, `!` and `~` bind tightly like in C
+		operand := par.parseExpression(prefixPrecedence)
+		if operand == nil {
+			return nil
+		}
+		left = &UnaryExpr{Operator: token.kind, Operand: operand}
 	}
+	par.setSpan(left, token)
 
 	for prec < precedence(par.current().kind) {
 		switch par.current().kind {
-		case Plus, Minus, Mul, Div, EqEq, Neq, Gt, Lt, Gte, Lte, Mod:
+		case Plus, Minus, Mul, Div, EqEq, Neq, Gt, Lt, Gte, Lte, Mod, And, Or,
+			BitAnd, BitOr, BitXor, LShift, RShift:
 			op := par.current().kind
 			par.next()
 			right := par.parseExpression(precedence(op))
 			left = &BinaryExpr{Left: left, Operator: op, Right: right}
+			par.setSpan(left, token)
+		case LBracket:
+			if left = par.parseIndex(left); left == nil {
+				return nil
+			}
+			par.setSpan(left, token)
+		case Period:
+			par.next()
+			if err := par.assertToken(par.current(), Identifier, "Expected a field name"); err != nil {
+				return nil
+			}
+			left = &FieldExpr{Target: left, Field: par.current().val}
+			par.next()
+			par.setSpan(left, token)
+		case LParen:
+			// calls by name are parsed with the name, this calls whatever
+			// function value left evaluates to, e.g. `fs[0](3)`
+			args, ok := par.parseCallArgs()
+			if !ok {
+				return nil
+			}
+			left = &CallExpr{Callee: left, Args: args}
+			par.setSpan(left, token)
 		default:
 			return left
 		}
@@ -539,16 +1190,36 @@ func (par *Parser) parseExpression(prec int) Expr {
 	return left
 }
 
+// prefixPrecedence is how tightly unary operators bind their operand
+const prefixPrecedence = 70
+
+// precedence follows C, except that `not` sits between the comparisons and `and`
 func precedence(tk tokenKind) int {
 	switch tk {
-	case Plus, Minus:
-		return 10
+	case LBracket, Period, LParen:
+		// indexing, field access and calls bind tighter than any prefix
+		// operator, `-a[0]` is `-(a[0])`
+		return 80
 	case Mul, Div, Mod:
-		return 20
+		return 60
+	case Plus, Minus:
+		return 50
+	case LShift, RShift:
+		return 45
 	case Neq, Gt, EqEq, Lt, Gte, Lte:
-		return 5
-	case And, Or:
-		return 3
+		return 40
+	case BitAnd:
+		return 35
+	case BitXor:
+		return 30
+	case BitOr:
+		return 25
+	case Not:
+		return 20
+	case And:
+		return 15
+	case Or:
+		return 10
 	case If:
 		return 1
 	default:
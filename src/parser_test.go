@@ -0,0 +1,132 @@
+package src
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// parse returns the statements of src that parsed and every ParseError
+func parse(t *testing.T, src string) ([]Node, []ParseError) {
+	t.Helper()
+	parser, lexErrs := NewInputLexer(src).Tokenize()
+	for _, err := range lexErrs {
+		t.Fatal(parser.PrintLexError(err))
+	}
+	ast, errs := parser.Parse()
+	return ast.Root.(*Program).Statements, errs
+}
+
+func TestDocComments(t *testing.T) {
+	stmts, errs := parse(t, `
+/// Adds two numbers.
+///   Indentation after the marker is kept.
+def add(a: int, b: int) -> int {
+	return a + b
+}
+// a plain comment is not a doc
+def sub(a: int, b: int) -> int {
+	return a - b
+}
+/// floating doc comments are dropped
+let x = 1
+/// An exported function.
+pub def neg(a: int) -> int {
+	return -a
+}
+/// An exported struct.
+pub struct Point {
+	x: int
+}
+`)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if doc := stmts[0].(*FuncDef).Doc; doc != "Adds two numbers.\n  Indentation after the marker is kept." {
+		t.Errorf("got doc %q", doc)
+	}
+	if doc := stmts[1].(*FuncDef).Doc; doc != "" {
+		t.Errorf("expected no doc on sub, got %q", doc)
+	}
+	if _, ok := stmts[2].(*LetExpr); !ok || len(stmts) != 5 {
+		t.Fatalf("expected the doc comment before let to be skipped, got %v", stmts)
+	}
+	if doc := stmts[3].(*FuncDef).Doc; doc != "An exported function." {
+		t.Errorf("got doc %q on pub def", doc)
+	}
+	if doc := stmts[4].(*StructDef).Doc; doc != "An exported struct." {
+		t.Errorf("got doc %q on pub struct", doc)
+	}
+}
+
+func TestCollectDocComments(t *testing.T) {
+	tokens := []Token{
+		{kind: DocComment, val: "one"},
+		{kind: DocComment, val: "two"},
+		{kind: Defn},
+		{kind: Identifier},
+		{kind: DocComment, val: "three"},
+		{kind: Struct},
+	}
+	filtered, docs := collectDocComments(tokens)
+	if len(filtered) != 3 {
+		t.Fatalf("expected the doc comments to be removed, got %v", filtered)
+	}
+	if docs[0] != "one\ntwo" || docs[2] != "three" || len(docs) != 2 {
+		t.Errorf("expected docs keyed by the token they precede, got %q", docs)
+	}
+}
+
+func TestParserRecovery(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		errs []string // line:col: msg of every error
+		kept int      // statements that parsed cleanly
+	}{
+		{"several errors", "let = 1\nlet y = 2\nprint(y +)\nprint(y)\nlet z 3", []string{
+			"1:5: Expected Identifier, got Eq",
+			"3:10: Unexpected token RParen",
+			"5:7: Expected Eq, got Literal",
+		}, 2},
+		{"bad header drops the body", "def f(a int) -> int {\n\tlet q = = 1\n\treturn a\n}\nprint(1)", []string{
+			"1:9: Expected RParen, got Int",
+		}, 1},
+		{"error in a block", "if true {\n\tlet a =\n\tprint(2)\n}\nprint(3)", []string{
+			"3:2: Unexpected token Print",
+		}, 1},
+		{"unclosed paren", "let x = (1 + 2\nprint(x)", []string{
+			"2:1: Expected RParen, got Print. You likely forgot a closing parenthesis",
+		}, 1},
+		{"unclosed block", "def f() -> int {\n\treturn 1\n", []string{
+			"3:1: Expected RBrace, got EOF. You likely forgot a closing brace",
+		}, 0},
+		// the unclosed parens would each report the EOF again
+		{"one error per token", "print((", []string{
+			"1:8: Unexpected end of file",
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmts, errs := parse(t, tt.src)
+			got := []string{}
+			for _, err := range errs {
+				got = append(got, fmt.Sprintf("%d:%d: %s", err.Span.Line(), err.Span.Col(), err.Msg))
+			}
+			if !slices.Equal(got, tt.errs) {
+				t.Errorf("got errors %q, want %q", got, tt.errs)
+			}
+			if len(stmts) != tt.kept {
+				t.Errorf("expected %d statements to be kept, got %d", tt.kept, len(stmts))
+			}
+		})
+	}
+}
+
+func TestSkipBlock(t *testing.T) {
+	parser, _ := NewInputLexer("{ a { b } { { c } } } d").Tokenize()
+	parser.skipBlock()
+	if tk := parser.current(); tk.kind != Identifier || tk.val != "d" {
+		t.Errorf("expected to stop after the matching brace, at %v", tk)
+	}
+}
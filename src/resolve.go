@@ -0,0 +1,464 @@
+package src
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+type symbolKind int
+
+const (
+	VarSymbol symbolKind = iota
+	ParamSymbol
+	FuncSymbol
+)
+
+// Symbol is a declared name. The Resolver binds every use of a name to the
+// Symbol of its declaration, so the later passes never look names up again
+type Symbol struct {
+	Name string
+	Kind symbolKind
+	Decl Node // the LetExpr, FnParam, ForLoop, ForIn or FuncDef that declared it
+	Span Span
+	used bool
+	// depth is how many functions the declaration is nested in, 0 for the
+	// top level. A lambda uses the variables of enclosing functions through
+	// captures, the top level ones it can reach directly
+	depth int
+	// inLoop is set for a variable declared in the body of a loop, or by a
+	// for-in loop, which is a fresh variable every iteration. A lambda has to
+	// capture it even at the top level to keep the value it saw
+	inLoop   bool
+	captured bool    // by at least one Lambda, so it must outlive its function
+	exported bool    // marked `pub` at the top level of its module
+	module   *Module // that declared it
+}
+
+// scope is one level of the symbol table: the program, a function, a loop or a block
+type scope struct {
+	parent  *scope
+	symbols map[string]*Symbol
+	order   []*Symbol // in declaration order, so warnings come out in source order
+}
+
+func (s *scope) lookup(name string) (*Symbol, bool) {
+	for ; s != nil; s = s.parent {
+		if sym, ok := s.symbols[name]; ok {
+			return sym, true
+		}
+	}
+	return nil, false
+}
+
+// Resolver binds every Ident, assignment and call to the Symbol it refers to,
+// reporting undeclared names as errors and shadowed or unused variables as warnings
+type Resolver struct {
+	ast      *AST
+	scope    *scope
+	bindings map[Node]*Symbol
+	depth    int           // of the function being resolved
+	loops    int           // around the code being resolved, in its function
+	lambdas  []lambdaScope // being resolved, the innermost last
+	captures map[*Lambda][]*Symbol
+	module   *Module // being resolved
+	top      *scope  // of the module being resolved
+	// exports are the symbols each resolved module exports, in declaration
+	// order, and private the names of its other top level declarations
+	exports  map[*Module][]*Symbol
+	private  map[*Module]map[string]bool
+	errors   []NameError
+	warnings []Warning
+}
+
+// lambdaScope is a Lambda being resolved and the depth of its body
+type lambdaScope struct {
+	lambda *Lambda
+	depth  int
+}
+
+func NewResolver(ast *AST) *Resolver {
+	return &Resolver{
+		ast:      ast,
+		bindings: make(map[Node]*Symbol),
+		captures: make(map[*Lambda][]*Symbol),
+		exports:  make(map[*Module][]*Symbol),
+		private:  make(map[*Module]map[string]bool),
+	}
+}
+
+// Resolve returns the AST with Symbols filled in
+func (r *Resolver) Resolve() (*AST, []NameError) {
+	for _, mod := range r.ast.modules() {
+		r.resolveModule(mod)
+	}
+	r.ast.Symbols = r.bindings
+	r.ast.Captures = r.captures
+	return r.ast, r.errors
+}
+
+// resolveModule resolves one file in a scope of its own, which starts out
+// with the names its imports export. The modules it imports were resolved
+// before it
+func (r *Resolver) resolveModule(mod *Module) {
+	r.module = mod
+	r.pushScope()
+	r.top = r.scope
+	imported := make(map[string]*Module)
+	for _, stmt := range mod.Statements {
+		imp, ok := stmt.(*ImportDecl)
+		if !ok || mod.Imports[imp] == nil {
+			continue
+		}
+		dep := mod.Imports[imp]
+		for _, sym := range r.exports[dep] {
+			if other, clash := imported[sym.Name]; clash {
+				r.errorAt(imp, fmt.Sprintf("%s is already imported from %s", sym.Name, other.Name))
+				continue
+			}
+			imported[sym.Name] = dep
+			// not added to the order, an import is never reported as unused
+			r.scope.symbols[sym.Name] = sym
+		}
+	}
+	// functions can be called before they are defined
+	for _, stmt := range mod.Statements {
+		if fn, ok := stmt.(*FuncDef); ok {
+			r.declareFunc(fn)
+		}
+	}
+	for _, stmt := range mod.Statements {
+		r.Visit(stmt)
+	}
+	r.private[mod] = make(map[string]bool)
+	for _, sym := range r.scope.order {
+		if sym.exported {
+			r.exports[mod] = append(r.exports[mod], sym)
+		} else {
+			r.private[mod][sym.Name] = true
+		}
+	}
+	r.popScope()
+}
+
+func (r *Resolver) Warnings() []Warning {
+	return r.warnings
+}
+
+func (r *Resolver) errorAt(node Node, msg string) {
+	r.errors = append(r.errors, NameError{Span: r.ast.Spans[node], Msg: msg})
+}
+
+func (r *Resolver) warnAt(span Span, msg string) {
+	r.warnings = append(r.warnings, Warning{Span: span, Msg: msg})
+}
+
+func (r *Resolver) pushScope() {
+	r.scope = &scope{parent: r.scope, symbols: make(map[string]*Symbol)}
+}
+
+// popScope leaves the current scope, warning about the variables it never used
+func (r *Resolver) popScope() {
+	for _, sym := range r.scope.order {
+		if sym.Kind != FuncSymbol && !sym.used && !sym.exported && !strings.HasPrefix(sym.Name, "_") {
+			r.warnAt(sym.Span, fmt.Sprintf("%s is declared but never used", sym.Name))
+		}
+	}
+	r.scope = r.scope.parent
+}
+
+func (r *Resolver) declare(name string, kind symbolKind, decl Node) *Symbol {
+	span := r.ast.Spans[decl]
+	// the loop spans its whole body, point at the variable instead
+	switch loop := decl.(type) {
+	case *ForLoop:
+		span = r.ast.Spans[loop.Var]
+	case *ForIn:
+		span = r.ast.Spans[loop.Var]
+	}
+	sym := &Symbol{Name: name, Kind: kind, Decl: decl, Span: span, depth: r.depth, inLoop: r.loops > 0, module: r.module}
+	prev, ok := r.scope.lookup(name)
+	switch {
+	case ok && r.scope == r.top && prev.module != r.module:
+		r.errorAt(decl, fmt.Sprintf("%s is already imported from %s", name, prev.module.Name))
+	case ok && kind != FuncSymbol:
+		r.warnAt(sym.Span, fmt.Sprintf("%s shadows the declaration on line %d", name, prev.Span.Line()))
+	}
+	r.scope.symbols[name] = sym
+	r.scope.order = append(r.scope.order, sym)
+	r.bindings[decl] = sym
+	return sym
+}
+
+func (r *Resolver) declareFunc(fn *FuncDef) {
+	if prev, exists := r.scope.symbols[fn.Name.Name]; exists && prev.module == r.module {
+		r.errorAt(fn, fmt.Sprintf("Function %s is already declared", fn.Name.Name))
+		// bind it to a symbol of its own, so it isn't declared again when visited
+		r.bindings[fn] = &Symbol{Name: fn.Name.Name, Kind: FuncSymbol, Decl: fn, Span: r.ast.Spans[fn]}
+		return
+	}
+	r.declare(fn.Name.Name, FuncSymbol, fn).exported = fn.Pub
+}
+
+// bind resolves a name used by node, reporting it if nothing declares it
+func (r *Resolver) bind(node Node, name string) (*Symbol, bool) {
+	sym, ok := r.scope.lookup(name)
+	if !ok {
+		r.undeclared(node, name, fmt.Sprintf("Undeclared variable %s", name))
+		return nil, false
+	}
+	r.bindings[node] = sym
+	r.capture(sym)
+	return sym, true
+}
+
+// undeclared reports a name nothing declares with msg, or that it is private
+// if an imported module declares it
+func (r *Resolver) undeclared(node Node, name, msg string) {
+	for _, dep := range r.module.Deps {
+		if r.private[dep][name] {
+			msg = fmt.Sprintf("%s is not exported by %s", name, dep.Name)
+			break
+		}
+	}
+	r.errorAt(node, msg)
+}
+
+// capture records sym as a capture of every lambda between its declaration
+// and the use being resolved, so each can hand it on to the next
+func (r *Resolver) capture(sym *Symbol) {
+	if sym.Kind == FuncSymbol || sym.depth == 0 && !sym.inLoop {
+		return
+	}
+	for i := len(r.lambdas) - 1; i >= 0 && r.lambdas[i].depth > sym.depth; i-- {
+		lambda := r.lambdas[i].lambda
+		if slices.Contains(r.captures[lambda], sym) {
+			// so do the lambdas around it
+			break
+		}
+		r.captures[lambda] = append(r.captures[lambda], sym)
+		sym.captured = true
+	}
+}
+
+// builtin is a function the VM implements, called like any other
+type builtin struct {
+	arity int
+	lower func(args []Expr) Expr
+}
+
+// builtins are not keywords, a program can declare the same names, which
+// then hide the builtin
+var builtins = map[string]builtin{
+	"len":    {1, func(args []Expr) Expr { return &LenCall{Value: args[0]} }},
+	"has":    {2, func(args []Expr) Expr { return &HasCall{Map: args[0], Key: args[1]} }},
+	"keys":   {1, func(args []Expr) Expr { return &KeysCall{Map: args[0]} }},
+	"delete": {2, func(args []Expr) Expr { return &DeleteCall{Map: args[0], Key: args[1]} }},
+}
+
+// lowerBuiltin sets the node a call of an undeclared builtin compiles to,
+// the arguments are resolved with the call
+func (r *Resolver) lowerBuiltin(call *CallExpr, fn builtin) {
+	if len(call.Args.Args) != fn.arity {
+		r.errorAt(call, fmt.Sprintf("%s expects %s, got %d", call.Function.Name, plural(fn.arity, "argument"), len(call.Args.Args)))
+		return
+	}
+	args := make([]Expr, len(call.Args.Args))
+	for i, arg := range call.Args.Args {
+		args[i] = arg.Value
+	}
+	call.Builtin = fn.lower(args)
+	r.ast.Spans[call.Builtin] = r.ast.Spans[call]
+}
+
+func (r *Resolver) Visit(node Node) {
+	switch n := node.(type) {
+	case nil:
+		return
+	case *FuncDef:
+		if _, hoisted := r.bindings[n]; !hoisted {
+			// only top level functions are compiled out of line, still
+			// declared so its uses aren't reported too
+			r.errorAt(n, "Functions can only be declared at the top level, assign a lambda to a variable instead")
+			r.declareFunc(n)
+		}
+		if n.Pub && r.scope != r.top {
+			r.errorAt(n, "Only top level declarations can be exported")
+		}
+		r.visitFunc(n.Params, n.Body)
+	case *Block:
+		r.visitBlock(n)
+	case *LetExpr:
+		// the value is resolved first, so `let x = x + 1` refers to an outer x
+		r.resolveExpr(n.Value)
+		r.declare(n.Variable.Name, VarSymbol, n).exported = n.Pub
+		if n.Pub && r.scope != r.top {
+			r.errorAt(n, "Only top level declarations can be exported")
+		}
+	case *ImportDecl:
+		if _, ok := r.module.Imports[n]; !ok && r.scope != r.top {
+			r.errorAt(n, "Imports can only be at the top level")
+		}
+	case *StructDef:
+		// struct names are types, the Checker looks them up
+		return
+	case *FieldAssign:
+		r.resolveExpr(n.Target)
+		r.resolveExpr(n.Value)
+	case *IndexAssign:
+		r.resolveExpr(n.Target)
+		r.resolveExpr(n.Index)
+		r.resolveExpr(n.Value)
+	case *ReAssignExpr:
+		r.resolveExpr(n.NewValue)
+		if sym, ok := r.bind(n, n.Variable.Name); ok && sym.Kind == FuncSymbol {
+			r.errorAt(n, fmt.Sprintf("Cannot assign to function %s", sym.Name))
+		}
+	case *PrintCall:
+		r.resolveExpr(n.Value)
+	case *ReturnExpr:
+		r.resolveExpr(n.Value)
+	case *IfStmt:
+		r.resolveExpr(n.Condition)
+		r.visitBlock(n.IfBlock)
+		r.visitBlock(n.ElseBlock)
+	case *WhileLoop:
+		r.resolveExpr(n.Condition)
+		r.loops++
+		r.visitBlock(n.Body)
+		r.loops--
+	case *ForLoop:
+		// the loop scope holds a variable declared in the header
+		r.pushScope()
+		ident := n.Var.(*Ident)
+		r.resolveExpr(n.Start)
+		if n.IsDecl {
+			r.bindings[ident] = r.declare(ident.Name, VarSymbol, n)
+		} else {
+			r.bind(ident, ident.Name)
+		}
+		r.resolveExpr(n.Condition)
+		r.loops++
+		r.visitBlock(n.Body)
+		r.loops--
+		r.Visit(n.Step)
+		r.popScope()
+	case *ForIn:
+		r.resolveExpr(n.Iterable)
+		r.pushScope()
+		r.loops++
+		r.bindings[n.Var] = r.declare(n.Var.Name, VarSymbol, n)
+		r.visitBlock(n.Body)
+		r.loops--
+		r.popScope()
+	default:
+		r.resolveExpr(node)
+	}
+}
+
+// visitFunc resolves the parameters and body of a function or lambda one
+// level deeper than the code around it
+func (r *Resolver) visitFunc(params []FnParam, body *Block) {
+	outerLoops := r.loops
+	r.loops = 0
+	r.depth++
+	r.pushScope()
+	for i := range params {
+		r.declare(params[i].Name, ParamSymbol, &params[i])
+	}
+	for _, stmt := range body.Statements {
+		r.Visit(stmt)
+	}
+	r.popScope()
+	r.depth--
+	r.loops = outerLoops
+}
+
+func (r *Resolver) visitBlock(node Node) {
+	block, ok := node.(*Block)
+	if !ok {
+		return
+	}
+	r.pushScope()
+	for _, stmt := range block.Statements {
+		r.Visit(stmt)
+	}
+	r.popScope()
+}
+
+func (r *Resolver) resolveExpr(expr Expr) {
+	switch e := expr.(type) {
+	case *Ident:
+		// naming a function makes a function value of it
+		if sym, ok := r.bind(e, e.Name); ok {
+			sym.used = true
+		}
+	case *CallExpr:
+		// a call through a variable is checked against its type later
+		if e.Callee != nil {
+			r.resolveExpr(e.Callee)
+		} else if _, ok := r.scope.lookup(e.Function.Name); !ok {
+			if builtin, isBuiltin := builtins[e.Function.Name]; isBuiltin {
+				r.lowerBuiltin(e, builtin)
+			} else {
+				r.undeclared(e, e.Function.Name, fmt.Sprintf("Undefined function %s", e.Function.Name))
+			}
+		} else if sym, _ := r.bind(e, e.Function.Name); sym != nil {
+			sym.used = true
+		}
+		for _, arg := range e.Args.Args {
+			r.resolveExpr(arg.Value)
+		}
+	case *Lambda:
+		r.lambdas = append(r.lambdas, lambdaScope{lambda: e, depth: r.depth + 1})
+		r.visitFunc(e.Params, e.Body)
+		r.lambdas = r.lambdas[:len(r.lambdas)-1]
+	case *FuncArg:
+		r.resolveExpr(e.Value)
+	case *BinaryExpr:
+		r.resolveExpr(e.Left)
+		r.resolveExpr(e.Right)
+	case *UnaryExpr:
+		r.resolveExpr(e.Operand)
+	case *IfExpr:
+		r.resolveExpr(e.Condition)
+		r.resolveExpr(e.Then)
+		r.resolveExpr(e.Else)
+	case *InterpString:
+		for _, part := range e.Parts {
+			r.resolveExpr(part)
+		}
+	case *Array:
+		for _, item := range e.Items {
+			r.resolveExpr(item)
+		}
+	case *IndexExpr:
+		r.resolveExpr(e.Target)
+		r.resolveExpr(e.Index)
+	case *LenCall:
+		r.resolveExpr(e.Value)
+	case *MapLit:
+		for i := range e.Keys {
+			r.resolveExpr(e.Keys[i])
+			r.resolveExpr(e.Values[i])
+		}
+	case *HasCall:
+		r.resolveExpr(e.Map)
+		r.resolveExpr(e.Key)
+	case *KeysCall:
+		r.resolveExpr(e.Map)
+	case *DeleteCall:
+		r.resolveExpr(e.Map)
+		r.resolveExpr(e.Key)
+	case *StructLit:
+		for _, field := range e.Fields {
+			r.resolveExpr(field.Value)
+		}
+	case *FieldExpr:
+		r.resolveExpr(e.Target)
+	case *InputIntCall:
+		r.resolveExpr(e.Input)
+	case *InputStrCall:
+		r.resolveExpr(e.Input)
+	}
+}
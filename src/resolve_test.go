@@ -0,0 +1,166 @@
+package src
+
+import (
+	"testing"
+)
+
+// resolve is what the Resolver reports for src, which must parse cleanly
+func resolve(t *testing.T, src string) ([]NameError, []Warning) {
+	t.Helper()
+	res := frontend(t, NewInputLexer(src))
+	errs := []NameError{}
+	for _, err := range res.errs {
+		switch err := err.(type) {
+		case NameError:
+			errs = append(errs, err)
+		case TypeError:
+			// the Resolver was happy, the Checker ran after it
+			return errs, res.warnings
+		default:
+			t.Fatal(res.loader.Print(err))
+		}
+	}
+	return errs, res.warnings
+}
+
+func TestResolverErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		line int
+		col  int
+		msg  string
+	}{
+		{"undeclared", `print(y)`, 1, 7, "Undeclared variable y"},
+		{"undefined function", `g()`, 1, 1, "Undefined function g"},
+		{"out of block scope", "if true {\n\tlet a = 1\n\tprint(a)\n}\nprint(a)", 5, 7, "Undeclared variable a"},
+		{"out of loop scope", "for (let i = 0; i < 2; i++) {\n\tprint(i)\n}\nprint(i)", 4, 7, "Undeclared variable i"},
+		{"function local", "def f() -> int {\n\tlet n = 1\n\treturn n\n}\nprint(n)", 5, 7, "Undeclared variable n"},
+		{"assign to function", "def f() -> int {\n\treturn 1\n}\nf = 2", 4, 1, "Cannot assign to function f"},
+		{"nested function", "def outer() -> int {\n\tdef inner(n: int) -> int {\n\t\treturn n\n\t}\n\treturn inner(1)\n}", 2, 2, "Functions can only be declared at the top level, assign a lambda to a variable instead"},
+		{"function in a block", "if true {\n\tdef f() -> int {\n\t\treturn 1\n\t}\n}", 2, 2, "Functions can only be declared at the top level, assign a lambda to a variable instead"},
+		{"builtin arity", `print(len([1], [2]))`, 1, 7, "len expects 1 argument, got 2"},
+		{"delete arity", "let m = {\"a\": 1}\ndelete(m)", 2, 1, "delete expects 2 arguments, got 1"},
+		{"redeclared function", "def f() -> int {\n\treturn 1\n}\ndef f() -> int {\n\treturn 2\n}", 4, 1, "Function f is already declared"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs, _ := resolve(t, tt.src)
+			if len(errs) != 1 {
+				t.Fatalf("expected 1 error, got %d: %v", len(errs), errs)
+			}
+			err := errs[0]
+			if err.Msg != tt.msg || err.Span.Line() != tt.line || err.Span.Col() != tt.col {
+				t.Errorf("got %v: %q, want %d:%d: %q", err.Span, err.Msg, tt.line, tt.col, tt.msg)
+			}
+		})
+	}
+}
+
+func TestResolverWarnings(t *testing.T) {
+	errs, warnings := resolve(t, `
+let x = 1
+let unused = 2
+let _ignored = 3
+def f(n: int, _m: int) -> int {
+	let x = n
+	return x
+}
+print(f(x, 0))
+`)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	want := []string{
+		"x shadows the declaration on line 2",
+		"unused is declared but never used",
+	}
+	if len(warnings) != len(want) {
+		t.Fatalf("expected %d warnings, got %d: %v", len(want), len(warnings), warnings)
+	}
+	for i, w := range warnings {
+		if w.Msg != want[i] {
+			t.Errorf("got %q, want %q", w.Msg, want[i])
+		}
+	}
+}
+
+func TestLoopVariableSpans(t *testing.T) {
+	_, warnings := resolve(t, `let i = 1
+for (let i = 0; i < 2; i++) {
+}
+print(i)
+for (let unused = 0; true; i = i + 1) {
+}
+for x in [1, 2] {
+}
+`)
+	want := []struct {
+		line, col int
+		msg       string
+	}{
+		{2, 10, "i shadows the declaration on line 1"},
+		{5, 10, "unused is declared but never used"},
+		{7, 5, "x is declared but never used"},
+	}
+	if len(warnings) != len(want) {
+		t.Fatalf("expected %d warnings, got %d: %v", len(want), len(warnings), warnings)
+	}
+	for i, w := range warnings {
+		if w.Msg != want[i].msg || w.Span.Line() != want[i].line || w.Span.Col() != want[i].col {
+			t.Errorf("got %v: %q, want %d:%d: %q", w.Span, w.Msg, want[i].line, want[i].col, want[i].msg)
+		}
+	}
+}
+
+func TestResolverBindsToDeclaration(t *testing.T) {
+	ast := check(t, NewInputLexer(`
+let x = 1
+if true {
+	let x = 2
+	print(x)
+}
+print(x)
+`))
+	stmts := ast.Root.(*Program).Statements
+	outer := stmts[0].(*LetExpr)
+	block := stmts[1].(*IfStmt).IfBlock.(*Block)
+	inner := block.Statements[0].(*LetExpr)
+	innerUse := block.Statements[1].(*PrintCall).Value
+	outerUse := stmts[2].(*PrintCall).Value
+	if ast.Symbols[innerUse] != ast.Symbols[inner] {
+		t.Error("x in the block should refer to the block's x")
+	}
+	if ast.Symbols[outerUse] != ast.Symbols[outer] {
+		t.Error("x after the block should refer to the outer x")
+	}
+}
+
+func TestResolverCaptures(t *testing.T) {
+	ast := check(t, NewInputLexer(`
+let g = 1
+def f(n: int) -> int {
+	let outer = fn() -> int {
+		let inner = fn() -> int {
+			return n + g
+		}
+		return inner()
+	}
+	return outer()
+}
+print(f(1))
+`))
+	fn := ast.Root.(*Program).Statements[1].(*FuncDef)
+	outer := fn.Body.Statements[0].(*LetExpr).Value.(*Lambda)
+	inner := outer.Body.Statements[0].(*LetExpr).Value.(*Lambda)
+	n := ast.Symbols[&fn.Params[0]]
+	// outer hands n on to inner, the top level g needs no capturing
+	for _, lambda := range []*Lambda{outer, inner} {
+		if captures := ast.Captures[lambda]; len(captures) != 1 || captures[0] != n {
+			t.Errorf("expected a lambda to capture only n, got %v", captures)
+		}
+	}
+	if !n.captured {
+		t.Error("n should be marked as captured")
+	}
+}
@@ -11,16 +11,43 @@ type Token struct {
 	span Span
 }
 
+// Span is a 1-based source range, end is exclusive
 type Span struct {
-	line int
-	pos  int
-	len  int
+	file    string
+	line    int
+	col     int
+	endLine int
+	endCol  int
 }
 
 func (tk *Token) Span() Span {
 	return tk.span
 }
 
+func (s Span) File() string {
+	return s.file
+}
+
+func (s Span) Line() int {
+	return s.line
+}
+
+func (s Span) Col() int {
+	return s.col
+}
+
+func (s Span) EndLine() int {
+	return s.endLine
+}
+
+func (s Span) EndCol() int {
+	return s.endCol
+}
+
+func (s Span) String() string {
+	return fmt.Sprintf("%s:%d:%d", s.file, s.line, s.col)
+}
+
 const (
 	Red   = "\033[31m"
 	Reset = "\033[0m"
@@ -31,16 +58,16 @@ const (
 
 type tokenKind int
 
-func newToken(kind tokenKind, val string, line int, pos int) Token {
+func newToken(kind tokenKind, val string, span Span) Token {
 	return Token{
 		kind: kind,
 		val:  val,
-		span: Span{line: line, pos: pos, len: len(val)},
+		span: span,
 	}
 }
 
 func (tk *Token) Print() {
-	fmt.Printf("TOKEN: %v  line: %d\n", tk.val, tk.span.line)
+	fmt.Printf("TOKEN: %v  %v\n", tk.val, tk.span)
 }
 
 const (
@@ -48,14 +75,21 @@ const (
 
 	Underscore
 	Literal
+	FloatLit
 	Identifier
+	DocComment
 
 	/* Delimiters */
 	Quote
+	Backtick
+	InterpStart // start of an interpolated string
+	InterpEnd   // end of an interpolated string
 	LParen
 	RParen
 	LBrace
 	RBrace
+	LBracket
+	RBracket
 	Period
 	Comma
 	Arrow
@@ -81,6 +115,20 @@ const (
 	Lt     // <    (less than)
 	LShift // <<  (bitshift left)
 	RShift // >>  (bitshift right)
+	Incr   // ++  (increment)
+	Decr   // --  (decrement)
+
+	/* Compound assignment */
+	PlusEq   // +=
+	MinusEq  // -=
+	MulEq    // *=
+	DivEq    // /=
+	ModEq    // %=
+	LShiftEq // <<=
+	RShiftEq // >>=
+	BitAndEq // &=
+	BitOrEq  // |=
+	BitXorEq // ^=
 
 	/* Keywords */
 	If
@@ -89,6 +137,7 @@ const (
 	Let
 	Then
 	Int
+	Float
 	Colon
 	Semicolon
 	False
@@ -106,6 +155,10 @@ const (
 	Bool
 	String
 	Defn
+	Struct
+	Fn
+	Import
+	Pub
 )
 
 func (tk tokenKind) ToString() string {
@@ -116,8 +169,20 @@ func (tk tokenKind) ToString() string {
 		return "Underscore"
 	case Literal:
 		return "Literal"
+	case FloatLit:
+		return "FloatLit"
 	case Identifier:
 		return "Identifier"
+	case DocComment:
+		return "DocComment"
+	case Quote:
+		return "Quote"
+	case Backtick:
+		return "Backtick"
+	case InterpStart:
+		return "InterpStart"
+	case InterpEnd:
+		return "InterpEnd"
 	case LParen:
 		return "LParen"
 	case RParen:
@@ -164,6 +229,30 @@ func (tk tokenKind) ToString() string {
 		return "LShift"
 	case RShift:
 		return "RShift"
+	case Incr:
+		return "Incr"
+	case Decr:
+		return "Decr"
+	case PlusEq:
+		return "PlusEq"
+	case MinusEq:
+		return "MinusEq"
+	case MulEq:
+		return "MulEq"
+	case DivEq:
+		return "DivEq"
+	case ModEq:
+		return "ModEq"
+	case LShiftEq:
+		return "LShiftEq"
+	case RShiftEq:
+		return "RShiftEq"
+	case BitAndEq:
+		return "BitAndEq"
+	case BitOrEq:
+		return "BitOrEq"
+	case BitXorEq:
+		return "BitXorEq"
 	case If:
 		return "If"
 	case Arrow:
@@ -190,6 +279,10 @@ func (tk tokenKind) ToString() string {
 		return "Not"
 	case LBrace:
 		return "LBrace"
+	case LBracket:
+		return "LBracket"
+	case RBracket:
+		return "RBracket"
 	case Return:
 		return "Return"
 	case RBrace:
@@ -204,12 +297,30 @@ func (tk tokenKind) ToString() string {
 		return "Print"
 	case Defn:
 		return "Defn"
+	case Struct:
+		return "Struct"
+	case Fn:
+		return "Fn"
+	case Import:
+		return "Import"
+	case Pub:
+		return "Pub"
 	case Void:
 		return "Void"
 	case Int:
 		return "Int"
+	case Float:
+		return "Float"
 	case For:
 		return "For"
+	case While:
+		return "While"
+	case Bool:
+		return "Bool"
+	case Dash:
+		return "Dash"
+	case Pipe:
+		return "Pipe"
 	case InputStr:
 		return "InputStr"
 	default:
@@ -253,18 +364,60 @@ func doubleOp(l, r tokenKind) tokenKind {
 			return Neq
 		}
 	case Minus:
-		if r == Gt {
+		switch r {
+		case Gt:
 			return Arrow
+		case Minus:
+			return Decr
+		default:
+			return EOF
+		}
+	case Plus:
+		if r == Plus {
+			return Incr
 		}
 	}
 	return EOF
 }
 
+// compoundOp returns the `op=` form of a binary operator, or EOF if it has none
+func compoundOp(op tokenKind) tokenKind {
+	switch op {
+	case Plus:
+		return PlusEq
+	case Minus:
+		return MinusEq
+	case Mul:
+		return MulEq
+	case Div:
+		return DivEq
+	case Mod:
+		return ModEq
+	case LShift:
+		return LShiftEq
+	case RShift:
+		return RShiftEq
+	case BitAnd:
+		return BitAndEq
+	case BitOr:
+		return BitOrEq
+	case BitXor:
+		return BitXorEq
+	default:
+		return EOF
+	}
+}
+
+// isDigit only accepts ASCII digits, other unicode digits can't be parsed as numbers
+func isDigit(char rune) bool {
+	return char >= '0' && char <= '9'
+}
+
 func fromChar(char rune) tokenKind {
 	if unicode.IsLetter(char) {
 		return Identifier
 	}
-	if unicode.IsDigit(char) {
+	if isDigit(char) {
 		return Literal
 	}
 	switch char {
@@ -276,6 +429,10 @@ func fromChar(char rune) tokenKind {
 		return LParen
 	case ')':
 		return RParen
+	case '[':
+		return LBracket
+	case ']':
+		return RBracket
 	case '.':
 		return Period
 	case '-':
@@ -314,6 +471,8 @@ func fromChar(char rune) tokenKind {
 		return BitNot
 	case '"':
 		return Quote
+	case '`':
+		return Backtick
 	case '\x00':
 		return EOF
 	default:
@@ -0,0 +1,180 @@
+package src
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Type is the static type of a value, as inferred by the Checker
+type Type interface {
+	String() string
+}
+
+// BasicType is one of the builtin scalar types
+type BasicType struct {
+	Kind tokenKind
+}
+
+func (t *BasicType) String() string {
+	switch t.Kind {
+	case Int:
+		return "int"
+	case Float:
+		return "float"
+	case String:
+		return "str"
+	case Bool:
+		return "bool"
+	case Void:
+		return "void"
+	default:
+		return "invalid"
+	}
+}
+
+var (
+	IntType   Type = &BasicType{Int}
+	FloatType Type = &BasicType{Float}
+	StrType   Type = &BasicType{String}
+	BoolType  Type = &BasicType{Bool}
+	VoidType  Type = &BasicType{Void}
+	// invalidType is given to expressions that already had an error, so
+	// the mistake isn't reported again by everything that uses them
+	invalidType Type = &BasicType{EOF}
+)
+
+// ArrayType is written `[elem]`, e.g. `[int]`
+type ArrayType struct {
+	Elem Type
+}
+
+func (t *ArrayType) String() string {
+	return fmt.Sprintf("[%v]", t.Elem)
+}
+
+// MapType is written `map[key]value`, e.g. `map[str]int`
+type MapType struct {
+	Key   Type
+	Value Type
+}
+
+func (t *MapType) String() string {
+	return fmt.Sprintf("map[%v]%v", t.Key, t.Value)
+}
+
+// StructType is a declared struct. The parser only knows the name of a struct
+// used in an annotation, the Checker swaps it for the declared one with fields
+type StructType struct {
+	Name   string
+	Fields []StructField
+	Module string // that declared it, "" for the program being run
+}
+
+// String qualifies a struct from an imported module with the module's name,
+// so two structs of the same name can be told apart in a message
+func (t *StructType) String() string {
+	if t.Module != "" {
+		return t.Module + "." + t.Name
+	}
+	return t.Name
+}
+
+// field is the position and type of a field, -1 if there is no such field
+func (t *StructType) field(name string) (int, Type) {
+	for i, f := range t.Fields {
+		if f.Name == name {
+			return i, f.Type
+		}
+	}
+	return -1, nil
+}
+
+// FuncType is the signature of a function, collected by the Checker before
+// any body is checked so calls don't depend on the order of definitions.
+// Function values are written the same way, e.g. `fn(int, int) -> int`
+type FuncType struct {
+	Params []Type
+	Ret    Type
+}
+
+func (t *FuncType) String() string {
+	params := make([]string, len(t.Params))
+	for i, param := range t.Params {
+		params[i] = param.String()
+	}
+	return fmt.Sprintf("fn(%s) -> %v", strings.Join(params, ", "), t.Ret)
+}
+
+// basicType maps a type keyword from the source to its Type
+func basicType(kind tokenKind) Type {
+	switch kind {
+	case Int:
+		return IntType
+	case Float:
+		return FloatType
+	case String:
+		return StrType
+	case Bool:
+		return BoolType
+	default:
+		return VoidType
+	}
+}
+
+// sameType compares types by structure, except that a struct is only the
+// same as itself, two modules can each declare a struct of the same name
+func sameType(a, b Type) bool {
+	switch a := a.(type) {
+	case *ArrayType:
+		b, ok := b.(*ArrayType)
+		return ok && sameType(a.Elem, b.Elem)
+	case *MapType:
+		b, ok := b.(*MapType)
+		return ok && sameType(a.Key, b.Key) && sameType(a.Value, b.Value)
+	case *FuncType:
+		b, ok := b.(*FuncType)
+		if !ok || len(a.Params) != len(b.Params) || !sameType(a.Ret, b.Ret) {
+			return false
+		}
+		for i := range a.Params {
+			if !sameType(a.Params[i], b.Params[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		// the basic types are never copied
+		return a == b
+	}
+}
+
+func isArray(t Type) bool {
+	_, ok := t.(*ArrayType)
+	return ok
+}
+
+// isReference reports whether values of type t live on the heap, comparing
+// them would only compare identity
+func isReference(t Type) bool {
+	switch t.(type) {
+	case *ArrayType, *StructType, *MapType, *FuncType:
+		return true
+	default:
+		return false
+	}
+}
+
+// isHashable reports whether t can be the key of a map
+func isHashable(t Type) bool {
+	return t == IntType || t == StrType || t == BoolType
+}
+
+func isNumeric(t Type) bool {
+	return t == IntType || t == FloatType
+}
+
+// assignable reports whether a value of type from can be stored where a
+// value of type to is expected, the only implicit conversion is int to float
+func assignable(to, from Type) bool {
+	return sameType(to, from) || (to == FloatType && from == IntType)
+}
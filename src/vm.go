@@ -3,24 +3,81 @@ package src
 import (
 	"fmt"
 	"log/slog"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type GoVM struct {
 	program   []Instruction
 	pc        int
 	Regs      map[string]int
-	registers [100]interface{}
+	registers [maxRegisters]interface{}
 	callStack Stack[int]
 	stack     Stack[interface{}]
 	labels    map[string]int
 	symbols   map[string]interface{}
+	heap      []interface{} // arrays and objects, registers hold a Ref to them
 }
 
+// object is a struct value on the heap
+type object struct {
+	name   string
+	fields []string // the field names, for printing
+	values []interface{}
+}
+
+// closure is a function value on the heap, the label of its code and the
+// cells it captured. Named functions are closures that captured nothing
+type closure struct {
+	name  string // empty for a lambda
+	label string
+	env   []interface{}
+}
+
+// cell holds a variable that a lambda captured, shared by every closure
+// that captured it and the function that declared it
+type cell struct {
+	value interface{}
+}
+
+// hashMap is a map value on the heap, it remembers the order keys were
+// inserted in so printing and iterating are deterministic
+type hashMap struct {
+	keys   []interface{}
+	values map[interface{}]interface{}
+}
+
+func newHashMap() *hashMap {
+	return &hashMap{values: make(map[interface{}]interface{})}
+}
+
+func (m *hashMap) set(key, value interface{}) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *hashMap) delete(key interface{}) {
+	if _, ok := m.values[key]; !ok {
+		return
+	}
+	delete(m.values, key)
+	m.keys = slices.DeleteFunc(m.keys, func(k interface{}) bool { return k == key })
+}
+
+// Ref is how a register holds an object on the heap, so passing an array to
+// a function passes the array itself rather than a copy
+type Ref int
+
 func NewVM(insns []Instruction) *GoVM {
 	vm := &GoVM{
 		program:   insns,
 		pc:        0,
-		registers: [100]interface{}{},
+		registers: [maxRegisters]interface{}{},
 		stack:     Stack[interface{}]{},
 		callStack: Stack[int]{},
 		labels:    make(map[string]int),
@@ -51,7 +108,68 @@ func (vm *GoVM) push(val interface{}) {
 	vm.stack.Push(val)
 }
 
-func (vm *GoVM) Exec() {
+func (vm *GoVM) alloc(obj interface{}) Ref {
+	vm.heap = append(vm.heap, obj)
+	return Ref(len(vm.heap) - 1)
+}
+
+func (vm *GoVM) array(val interface{}) []interface{} {
+	return vm.heap[val.(Ref)].([]interface{})
+}
+
+func (vm *GoVM) object(val interface{}) *object {
+	return vm.heap[val.(Ref)].(*object)
+}
+
+func (vm *GoVM) cell(val interface{}) *cell {
+	return vm.heap[val.(Ref)].(*cell)
+}
+
+func (vm *GoVM) hashMap(val interface{}) *hashMap {
+	return vm.heap[val.(Ref)].(*hashMap)
+}
+
+// lookup fails if key is not in m
+func (vm *GoVM) lookup(m *hashMap, key interface{}) interface{} {
+	value, ok := m.values[key]
+	if !ok {
+		vm.fail("Key %s is not in the map", vm.formatInner(key))
+	}
+	return value
+}
+
+// fail stops the program with a RuntimeError, Exec recovers it
+func (vm *GoVM) fail(format string, args ...interface{}) {
+	panic(RuntimeError{Msg: fmt.Sprintf(format, args...)})
+}
+
+// checkBounds fails unless i is an index into arr
+func (vm *GoVM) checkBounds(arr []interface{}, i int) {
+	if i < 0 || i >= len(arr) {
+		vm.fail("Index %d out of bounds for an array of length %d", i, len(arr))
+	}
+}
+
+// checkDivisor fails on an integer division or modulo by zero, floats give
+// Inf or NaN instead
+func (vm *GoVM) checkDivisor(divisor int) int {
+	if divisor == 0 {
+		vm.fail("Division by zero")
+	}
+	return divisor
+}
+
+// Exec runs the program until it halts, or until a RuntimeError stops it
+func (vm *GoVM) Exec() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			rtErr, ok := r.(RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			err = rtErr
+		}
+	}()
 	for vm.pc < len(vm.program) {
 		op := vm.fetchNext()
 		switch op.Opcode {
@@ -101,11 +219,13 @@ func (vm *GoVM) Exec() {
 		case DIV:
 			// DIV reg1, reg2, dest
 			arg1, arg2, dest := vm.getThreeArgs(op.Args)
-			vm.registers[dest] = vm.registers[arg1].(int) / vm.registers[arg2].(int)
+			divisor := vm.checkDivisor(vm.registers[arg2].(int))
+			vm.registers[dest] = vm.registers[arg1].(int) / divisor
 		case MOD:
 			// MOD reg1, reg2, dest
 			arg1, arg2, dest := vm.getThreeArgs(op.Args)
-			vm.registers[dest] = vm.registers[arg1].(int) % vm.registers[arg2].(int)
+			divisor := vm.checkDivisor(vm.registers[arg2].(int))
+			vm.registers[dest] = vm.registers[arg1].(int) % divisor
 		case FNCALL:
 			// always push return value onto the stack
 			label := op.Args[0].(string)
@@ -114,6 +234,36 @@ func (vm *GoVM) Exec() {
 			vm.pc = vm.labels[label]
 			slog.Debug("PC after fncall: ", slog.String("label", label), slog.Int("pc", vm.pc))
 			continue
+		case CALLV:
+			// CALLV reg calls the closure in reg, its captures go on the data
+			// stack after the arguments
+			fn := vm.heap[vm.registers[op.Args[0].(int)].(Ref)].(*closure)
+			for _, captured := range fn.env {
+				vm.push(captured)
+			}
+			vm.callStack.Push(vm.pc + 1)
+			vm.pc = vm.labels[fn.label]
+			continue
+		case CLOSURE:
+			// CLOSURE dest, label, name, captured cells...
+			dest := op.Args[0].(int)
+			fn := &closure{label: op.Args[1].(string), name: op.Args[2].(string)}
+			for _, reg := range op.Args[3:] {
+				fn.env = append(fn.env, vm.registers[reg.(int)])
+			}
+			vm.registers[dest] = vm.alloc(fn)
+		case NEWCELL:
+			// NEWCELL value, dest
+			val, dest := getTwoArgs(op.Args)
+			vm.registers[dest] = vm.alloc(&cell{value: vm.registers[val]})
+		case GETCELL:
+			// GETCELL cell, dest
+			c, dest := getTwoArgs(op.Args)
+			vm.registers[dest] = vm.cell(vm.registers[c]).value
+		case SETCELL:
+			// SETCELL cell, value
+			c, val := getTwoArgs(op.Args)
+			vm.cell(vm.registers[c]).value = vm.registers[val]
 		case RET:
 			vm.pc = vm.callStack.Pop()
 			// the return value of the function should be in RAX
@@ -132,6 +282,12 @@ func (vm *GoVM) Exec() {
 					fmt.Printf("PRINT: %d\n", val)
 				case string:
 					fmt.Printf("PRINT: %s\n", val)
+				case float64:
+					fmt.Printf("PRINT: %s\n", formatFloat(val))
+				case bool:
+					fmt.Printf("PRINT: %t\n", val)
+				case Ref:
+					fmt.Printf("PRINT: %s\n", vm.format(val))
 				default:
 					fmt.Printf("%v", val)
 				}
@@ -161,20 +317,21 @@ func (vm *GoVM) Exec() {
 			reg := op.Args[0].(int)
 			reg2 := op.Args[1].(int)
 			label := op.Args[2].(string)
-			if vm.registers[reg].(int) == vm.registers[reg2].(int) {
+			// works for every scalar type, ints, bools and strings alike
+			if vm.registers[reg] == vm.registers[reg2] {
 				vm.pc = vm.findLabel(label)
 				continue
 			}
 		case JNT:
 			reg := op.Args[0].(int)
 			label := op.Args[1].(string)
-			if vm.registers[reg].(int) == 0 {
+			if !isTruthy(vm.registers[reg]) {
 				vm.pc = vm.findLabel(label)
 				continue
 			}
 		case JNE:
 			reg1, reg2 := getTwoArgs(op.Args)
-			if vm.registers[reg1].(int) != vm.registers[reg2].(int) {
+			if vm.registers[reg1] != vm.registers[reg2] {
 				label := op.Args[2].(string)
 				vm.pc = vm.findLabel(label)
 				continue
@@ -187,16 +344,8 @@ func (vm *GoVM) Exec() {
 				continue
 			}
 		case JLT:
-			arg1, arg2 := getTwoArgs(op.Args)
-			reg, ok := vm.registers[arg1].(int)
-			if !ok {
-				reg = arg1
-			}
-			reg2, ok := vm.registers[arg1].(int)
-			if !ok {
-				reg2 = arg2
-			}
-			if reg < reg2 {
+			reg1, reg2 := getTwoArgs(op.Args)
+			if vm.registers[reg1].(int) < vm.registers[reg2].(int) {
 				label := op.Args[2].(string)
 				vm.pc = vm.findLabel(label)
 				continue
@@ -219,7 +368,7 @@ func (vm *GoVM) Exec() {
 			label := op.Args[0].(string)
 			vm.labels[label] = vm.pc
 		case HALT:
-			return
+			return nil
 		case NOP:
 			vm.pc++
 			continue
@@ -241,16 +390,18 @@ func (vm *GoVM) Exec() {
 		case BNOT:
 			reg, dest := getTwoArgs(op.Args)
 			vm.registers[dest] = ^vm.registers[reg].(int)
+		case NEG:
+			reg, dest := getTwoArgs(op.Args)
+			vm.registers[dest] = -vm.registers[reg].(int)
+		case FNEG:
+			reg, dest := getTwoArgs(op.Args)
+			vm.registers[dest] = -vm.registers[reg].(float64)
 		case NOT:
 			reg, dest := getTwoArgs(op.Args)
-			if vm.registers[reg] == 0 {
-				vm.registers[dest] = 1
-			} else {
-				vm.registers[dest] = 0
-			}
+			vm.registers[dest] = !isTruthy(vm.registers[reg])
 		case MOV_IF:
 			condReg, thenReg, elseReg, dest := getFourArgs(op.Args)
-			if vm.registers[condReg] != 0 {
+			if isTruthy(vm.registers[condReg]) {
 				vm.registers[dest] = vm.registers[thenReg] // Move 'then' value
 			} else {
 				vm.registers[dest] = vm.registers[elseReg] // Move 'else' value
@@ -293,6 +444,137 @@ func (vm *GoVM) Exec() {
 				slog.Debug("Pushing register value onto the stack: ", slog.Any("val", vm.registers[val]))
 				vm.push(vm.registers[val])
 			}
+		case ITOF:
+			// ITOF reg, dest
+			reg, dest := getTwoArgs(op.Args)
+			vm.registers[dest] = float64(vm.registers[reg].(int))
+		case FADD:
+			arg1, arg2, dest := vm.getThreeArgs(op.Args)
+			vm.registers[dest] = vm.registers[arg1].(float64) + vm.registers[arg2].(float64)
+		case FSUB:
+			arg1, arg2, dest := vm.getThreeArgs(op.Args)
+			vm.registers[dest] = vm.registers[arg1].(float64) - vm.registers[arg2].(float64)
+		case FMUL:
+			arg1, arg2, dest := vm.getThreeArgs(op.Args)
+			vm.registers[dest] = vm.registers[arg1].(float64) * vm.registers[arg2].(float64)
+		case FDIV:
+			arg1, arg2, dest := vm.getThreeArgs(op.Args)
+			vm.registers[dest] = vm.registers[arg1].(float64) / vm.registers[arg2].(float64)
+		case FMOD:
+			arg1, arg2, dest := vm.getThreeArgs(op.Args)
+			vm.registers[dest] = math.Mod(vm.registers[arg1].(float64), vm.registers[arg2].(float64))
+		case FJEQ, FJNE, FJGT, FJLT, FJGE, FJLE:
+			// FJxx reg1, reg2, label
+			reg1, reg2 := getTwoArgs(op.Args)
+			if compareFloats(op.Opcode, vm.registers[reg1].(float64), vm.registers[reg2].(float64)) {
+				label := op.Args[2].(string)
+				vm.pc = vm.findLabel(label)
+				continue
+			}
+		case TOSTR:
+			// TOSTR reg, dest
+			reg, dest := getTwoArgs(op.Args)
+			vm.registers[dest] = vm.format(vm.registers[reg])
+		case SAVE:
+			// SAVE first, end pushes registers first up to end
+			first, end := getTwoArgs(op.Args)
+			for reg := first; reg < end; reg++ {
+				vm.push(vm.registers[reg])
+			}
+		case RESTORE:
+			// RESTORE first, end pops them back in reverse
+			first, end := getTwoArgs(op.Args)
+			for reg := end - 1; reg >= first; reg-- {
+				vm.registers[reg] = vm.pop()
+			}
+		case NEWARR:
+			// NEWARR count, dest pops count items off the data stack
+			count, dest := getTwoArgs(op.Args)
+			items := make([]interface{}, count)
+			for i := count - 1; i >= 0; i-- {
+				items[i] = vm.pop()
+			}
+			vm.registers[dest] = vm.alloc(items)
+		case INDEX:
+			// INDEX arr, index, dest also looks up the key of a map
+			arr, idx, dest := vm.getThreeArgs(op.Args)
+			if m, ok := vm.heap[vm.registers[arr].(Ref)].(*hashMap); ok {
+				vm.registers[dest] = vm.lookup(m, vm.registers[idx])
+				break
+			}
+			items := vm.array(vm.registers[arr])
+			i := vm.registers[idx].(int)
+			vm.checkBounds(items, i)
+			vm.registers[dest] = items[i]
+		case SETIDX:
+			// SETIDX arr, index, value also inserts into a map
+			arr, idx, val := vm.getThreeArgs(op.Args)
+			if m, ok := vm.heap[vm.registers[arr].(Ref)].(*hashMap); ok {
+				m.set(vm.registers[idx], vm.registers[val])
+				break
+			}
+			items := vm.array(vm.registers[arr])
+			i := vm.registers[idx].(int)
+			vm.checkBounds(items, i)
+			items[i] = vm.registers[val]
+		case NEWMAP:
+			// NEWMAP count, dest pops count key, value pairs off the data stack
+			count, dest := getTwoArgs(op.Args)
+			pairs := make([]interface{}, 2*count)
+			for i := len(pairs) - 1; i >= 0; i-- {
+				pairs[i] = vm.pop()
+			}
+			m := newHashMap()
+			for i := 0; i < len(pairs); i += 2 {
+				m.set(pairs[i], pairs[i+1])
+			}
+			vm.registers[dest] = vm.alloc(m)
+		case HAS:
+			// HAS map, key, dest
+			m, key, dest := vm.getThreeArgs(op.Args)
+			_, ok := vm.hashMap(vm.registers[m]).values[vm.registers[key]]
+			vm.registers[dest] = ok
+		case KEYS:
+			// KEYS map, dest makes a new array of the keys
+			m, dest := getTwoArgs(op.Args)
+			vm.registers[dest] = vm.alloc(slices.Clone(vm.hashMap(vm.registers[m]).keys))
+		case DELETE:
+			// DELETE map, key
+			m, key := getTwoArgs(op.Args)
+			vm.hashMap(vm.registers[m]).delete(vm.registers[key])
+		case NEWOBJ:
+			// NEWOBJ dest, name, fields... pops a value for each field
+			dest := op.Args[0].(int)
+			obj := &object{name: op.Args[1].(string)}
+			for _, field := range op.Args[2:] {
+				obj.fields = append(obj.fields, field.(string))
+			}
+			obj.values = make([]interface{}, len(obj.fields))
+			for i := len(obj.values) - 1; i >= 0; i-- {
+				obj.values[i] = vm.pop()
+			}
+			vm.registers[dest] = vm.alloc(obj)
+		case GETFIELD:
+			// GETFIELD obj, field, dest, the field is its position in the struct
+			obj, field, dest := vm.getThreeArgs(op.Args)
+			vm.registers[dest] = vm.object(vm.registers[obj]).values[field]
+		case SETFIELD:
+			// SETFIELD obj, field, value
+			obj, field, val := vm.getThreeArgs(op.Args)
+			vm.object(vm.registers[obj]).values[field] = vm.registers[val]
+		case LEN:
+			// LEN reg, dest works on arrays, maps and strings
+			reg, dest := getTwoArgs(op.Args)
+			switch val := vm.registers[reg].(type) {
+			case Ref:
+				if m, ok := vm.heap[val].(*hashMap); ok {
+					vm.registers[dest] = len(m.keys)
+					break
+				}
+				vm.registers[dest] = len(vm.array(val))
+			case string:
+				vm.registers[dest] = utf8.RuneCountInString(val)
+			}
 		case POP:
 			reg := op.Args[0].(int)
 			slog.Debug("reg: ", slog.Int("reg", reg))
@@ -300,6 +582,104 @@ func (vm *GoVM) Exec() {
 		}
 		vm.pc++
 	}
+	return nil
+}
+
+// isTruthy is what conditional jumps test, bools as they are and ints when non zero
+func isTruthy(val interface{}) bool {
+	switch v := val.(type) {
+	case bool:
+		return v
+	case int:
+		return v != 0
+	default:
+		panic(fmt.Sprintf("Expected a bool condition, got %v", val))
+	}
+}
+
+func toString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return formatFloat(v)
+	default:
+		panic(fmt.Sprintf("Cannot convert %v to a string", val))
+	}
+}
+
+// format is how print and interpolation show a value, arrays and structs
+// show what they hold with strings quoted
+func (vm *GoVM) format(val interface{}) string {
+	ref, ok := val.(Ref)
+	if !ok {
+		return toString(val)
+	}
+	switch obj := vm.heap[ref].(type) {
+	case []interface{}:
+		strs := make([]string, len(obj))
+		for i, item := range obj {
+			strs[i] = vm.formatInner(item)
+		}
+		return "[" + strings.Join(strs, ", ") + "]"
+	case *object:
+		strs := make([]string, len(obj.values))
+		for i, value := range obj.values {
+			strs[i] = obj.fields[i] + ": " + vm.formatInner(value)
+		}
+		return obj.name + " { " + strings.Join(strs, ", ") + " }"
+	case *hashMap:
+		strs := make([]string, len(obj.keys))
+		for i, key := range obj.keys {
+			strs[i] = vm.formatInner(key) + ": " + vm.formatInner(obj.values[key])
+		}
+		return "{" + strings.Join(strs, ", ") + "}"
+	case *closure:
+		if obj.name == "" {
+			return "<fn>"
+		}
+		return "<fn " + obj.name + ">"
+	default:
+		panic(fmt.Sprintf("Unknown heap object %v", obj))
+	}
+}
+
+// formatInner formats a value held by an array, struct or map
+func (vm *GoVM) formatInner(val interface{}) string {
+	if s, ok := val.(string); ok {
+		return strconv.Quote(s)
+	}
+	return vm.format(val)
+}
+
+// formatFloat prints floats in the shortest form, always keeping a decimal point
+func formatFloat(val float64) string {
+	str := strconv.FormatFloat(val, 'g', -1, 64)
+	if !strings.ContainsAny(str, ".eIN") {
+		str += ".0"
+	}
+	return str
+}
+
+func compareFloats(op Opcode, lhs, rhs float64) bool {
+	switch op {
+	case FJEQ:
+		return lhs == rhs
+	case FJNE:
+		return lhs != rhs
+	case FJGT:
+		return lhs > rhs
+	case FJLT:
+		return lhs < rhs
+	case FJGE:
+		return lhs >= rhs
+	default:
+		return lhs <= rhs
+	}
 }
 
 func (vm *GoVM) getThreeArgs(args []interface{}) (int, int, int) {